@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// requestIDHeader 是传递请求 ID 的请求头，客户端传入时沿用，否则由代理生成
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength 限制沿用的请求 ID 长度
+	maxRequestIDLength = 128
+)
+
+// requestID 返回客户端传入的请求 ID，没有或不合法时生成一个新的
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLength && printable(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// maskKey 只保留 API key 的前几位，避免完整的 key 出现在日志中
+func maskKey(key string) string {
+	if len(key) <= 6 {
+		return key
+	}
+	return key[:6] + "..."
+}
+
+// accessEntry 收集一次请求的访问日志字段
+type accessEntry struct {
+	ID       string
+	Client   clientInfo
+	Methods  string
+	Upstream string
+	Attempts int
+	Error    string
+}
+
+type accessEntryKey struct{}
+
+// withAccessEntry 将访问日志条目放入 context，转发时据此记录上游和重试次数
+func withAccessEntry(ctx context.Context, entry *accessEntry) context.Context {
+	return context.WithValue(ctx, accessEntryKey{}, entry)
+}
+
+// accessEntryFrom 取出 context 中的访问日志条目，没有时返回 nil
+func accessEntryFrom(ctx context.Context) *accessEntry {
+	entry, _ := ctx.Value(accessEntryKey{}).(*accessEntry)
+	return entry
+}
+
+// requestLogger 返回带请求 ID 的 logger
+func requestLogger(ctx context.Context) *slog.Logger {
+	if entry := accessEntryFrom(ctx); entry != nil {
+		return slog.With("request_id", entry.ID)
+	}
+	return slog.Default()
+}
+
+// accessRecorder 记录写回客户端的状态码和字节数
+type accessRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Hijack 供 WebSocket 升级使用
+func (rec *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rec.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (rec *accessRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logAccess 输出一行访问日志
+func logAccess(entry *accessEntry, rec *accessRecorder, start time.Time) {
+	status := rec.status
+	if rec.hijacked {
+		status = http.StatusSwitchingProtocols
+	}
+	retries := 0
+	if entry.Attempts > 1 {
+		retries = entry.Attempts - 1
+	}
+	attrs := []any{
+		"request_id", entry.ID,
+		"client_ip", entry.Client.IP,
+		"api_key", maskKey(entry.Client.Key),
+		"methods", entry.Methods,
+		"upstream", entry.Upstream,
+		"retries", retries,
+		"status", status,
+		"bytes", rec.bytes,
+		"duration", time.Since(start),
+	}
+	if entry.Error != "" {
+		attrs = append(attrs, "error", entry.Error)
+	}
+	slog.Info("access", attrs...)
+}
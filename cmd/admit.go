@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tedtool/cmd/keys"
+)
+
+const (
+	// apiKeyHeader 是客户端传递 API key 的请求头
+	apiKeyHeader = "X-API-Key"
+	// apiKeyPathPrefix 是以路径前缀传递 API key 时的前缀，例如 /key/<key>/status
+	apiKeyPathPrefix = "/key/"
+)
+
+// clientInfo 标识发起请求的客户端
+type clientInfo struct {
+	IP  string
+	Key string
+}
+
+// newClientInfo 取出客户端 IP 和 API key。key 只用于代理本身，
+// 请求头会被删除、路径前缀会被去掉，不会转发给上游
+func (h *proxyHandler) newClientInfo(r *http.Request) clientInfo {
+	client := clientInfo{IP: realClientIP(r, h.opts.TrustedProxies)}
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		client.Key = key
+		r.Header.Del(apiKeyHeader)
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, apiKeyPathPrefix); ok {
+		key, path, _ := strings.Cut(rest, "/")
+		if client.Key == "" {
+			client.Key = key
+		}
+		r.URL.Path = "/" + path
+		r.URL.RawPath = ""
+	}
+	return client
+}
+
+// admitError 是准入检查未通过的原因
+type admitError struct {
+	reason     string // 指标中的拒绝原因
+	status     int
+	code       int
+	message    string
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *admitError) Error() string {
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+// write 将准入错误以 JSON-RPC 错误返回给客户端
+func (e *admitError) write(w http.ResponseWriter, rpc *RPCRequest) {
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	writeRPCErrors(w, e.status, rpc, e.code, e.message, e.err.Error())
+}
+
+// admit 依次检查 API key、方法策略、开销上限、每日配额和限流，检查每日配额时记录 key 的用量。
+// 未配置 key 文件时不校验 key，携带 key 的请求同时受 IP 和 key 的限流；
+// 配置后 key 必须有效，按 key 所在等级限流，不再按 IP 限流
+func (h *proxyHandler) admit(ctx context.Context, client clientInfo, calls []RPCCall, cost float64) *admitError {
+	limits := []LimitKey{{Key: "ip:" + client.IP, Config: h.opts.IPLimit}}
+	var tier keys.Tier
+	validKey := false
+
+	switch {
+	case client.Key == "":
+	case h.opts.Keys == nil:
+		limits = append(limits, LimitKey{Key: "key:" + client.Key, Config: h.opts.KeyLimit})
+	default:
+		var ok bool
+		if _, tier, ok = h.opts.Keys.Lookup(client.Key); !ok {
+			return &admitError{reason: "invalid_key", status: http.StatusUnauthorized, code: codeInvalidAPIKey, message: "Invalid API key",
+				err: fmt.Errorf("unknown or revoked API key")}
+		}
+		validKey = true
+		limits = []LimitKey{{Key: "key:" + client.Key, Config: BucketConfig{Rate: tier.Rate, Burst: tier.Burst}}}
+	}
+
+	// 检查方法和 abci_query 路径是否被允许
+	for _, call := range calls {
+		if err := h.opts.Policy.Check(call); err != nil {
+			return &admitError{reason: "policy", status: http.StatusForbidden, code: codeMethodNotAllowed, message: "Method not allowed", err: err}
+		}
+		if validKey && len(tier.AllowMethods) > 0 && !matchMethod(tier.AllowMethods, call.Method) {
+			return &admitError{reason: "policy", status: http.StatusForbidden, code: codeMethodNotAllowed, message: "Method not allowed",
+				err: fmt.Errorf("method %q is not allowed for this API key", call.Method)}
+		}
+	}
+
+	// 开销超过桶容量的请求重试也不会成功，返回 413 而不是 429
+	if limit, ok := Exceeds(limits, cost); ok {
+		return &admitError{reason: "too_expensive", status: http.StatusRequestEntityTooLarge, code: codeRequestTooLarge, message: "Request too expensive",
+			err: fmt.Errorf("request cost %.4g exceeds the burst of %g cost units", cost, limit.Burst)}
+	}
+
+	// 检查每日配额并记录用量，配额在 UTC 零点重置。检查和记录一起完成，被限流拒绝时再退还
+	var methods []string
+	var day string
+	if validKey {
+		methods = make([]string, 0, len(calls))
+		for _, call := range calls {
+			methods = append(methods, call.Method)
+		}
+		var ok bool
+		if day, ok = h.opts.Keys.Charge(client.Key, methods, cost, tier.DailyQuota); !ok {
+			now := time.Now().UTC()
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			return &admitError{reason: "quota", status: http.StatusTooManyRequests, code: codeQuotaExceeded, message: "Daily quota exceeded",
+				retryAfter: midnight.Sub(now), err: fmt.Errorf("daily quota of %g cost units used up", tier.DailyQuota)}
+		}
+	}
+
+	if retryAfter, ok := h.limiter.Allow(ctx, limits, cost); !ok {
+		if validKey {
+			h.opts.Keys.Refund(client.Key, day, methods, cost)
+		}
+		return &admitError{reason: "rate_limit", status: http.StatusTooManyRequests, code: codeRateLimited, message: "Rate limit exceeded",
+			retryAfter: retryAfter, err: fmt.Errorf("retry after %v", retryAfter.Round(time.Millisecond))}
+	}
+	return nil
+}
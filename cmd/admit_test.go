@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tedtool/cmd/keys"
+)
+
+// openTestKeys 创建只含一个 key 的 key 文件，等级为 tier
+func openTestKeys(t *testing.T, tier string) *keys.Store {
+	t.Helper()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "keys.json")
+	data := `{"tiers":{"test":` + tier + `},"keys":{"tk_test":{"name":"test","tier":"test"}}}`
+	if err := os.WriteFile(keyPath, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := keys.Open(keyPath, filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestAdmitAPIKeys(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	store := openTestKeys(t, `{"rate":1000,"burst":1000,"daily_quota":3}`)
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.Keys = store
+	})
+
+	if w := serve(h, http.MethodGet, "/key/tk_unknown/status", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status = %d, want 401", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w := serve(h, http.MethodGet, "/key/tk_test/status", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d within quota: status = %d: %s", i, w.Code, w.Body)
+		}
+	}
+	w := serve(h, http.MethodGet, "/key/tk_test/status", "")
+	if w.Code != http.StatusTooManyRequests || rpcError(t, w.Body.Bytes()).Code != codeQuotaExceeded {
+		t.Errorf("request over quota: status = %d: %s", w.Code, w.Body)
+	}
+	if u := store.Usage("tk_test", time.Now().UTC().Format("2006-01-02")); u.Cost != 3 {
+		t.Errorf("usage = %g, want 3", u.Cost)
+	}
+}
+
+// TestAdmitRefundsRateLimited 检查被限流拒绝的请求不计入每日用量
+func TestAdmitRefundsRateLimited(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	store := openTestKeys(t, `{"rate":0.001,"burst":1,"daily_quota":100}`)
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.Keys = store
+	})
+
+	serve(h, http.MethodGet, "/key/tk_test/status", "")
+	w := serve(h, http.MethodGet, "/key/tk_test/status", "")
+	if w.Code != http.StatusTooManyRequests || rpcError(t, w.Body.Bytes()).Code != codeRateLimited {
+		t.Fatalf("status = %d, want rate limited: %s", w.Code, w.Body)
+	}
+	if u := store.Usage("tk_test", time.Now().UTC().Format("2006-01-02")); u.Cost != 1 || u.Methods["status"] != 1 {
+		t.Errorf("usage = %+v, want only the admitted request", u)
+	}
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy 从候选上游中选出一个，候选按从当前上游开始的顺序排列，且不为空
+type Strategy interface {
+	Choose(candidates []*Upstream) *Upstream
+}
+
+// strategies 是可选的负载均衡策略
+var strategies = map[string]func() Strategy{
+	"failover":             func() Strategy { return failoverStrategy{} },
+	"round-robin":          func() Strategy { return &roundRobinStrategy{} },
+	"weighted-round-robin": func() Strategy { return &weightedRoundRobinStrategy{current: make(map[*Upstream]int)} },
+	"least-outstanding":    func() Strategy { return leastOutstandingStrategy{} },
+	"lowest-latency":       func() Strategy { return lowestLatencyStrategy{} },
+	"p2c":                  func() Strategy { return p2cStrategy{} },
+}
+
+// strategyNames 返回所有策略名，用于帮助信息
+func strategyNames() string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// newStrategy 按名称创建负载均衡策略
+func newStrategy(name string) (Strategy, error) {
+	newFn, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, must be one of: %s", name, strategyNames())
+	}
+	return newFn(), nil
+}
+
+// failoverStrategy 总是选择当前上游，当前上游不可用时才使用下一个
+type failoverStrategy struct{}
+
+func (failoverStrategy) Choose(candidates []*Upstream) *Upstream {
+	return candidates[0]
+}
+
+// roundRobinStrategy 依次轮流选择候选上游
+type roundRobinStrategy struct {
+	next atomic.Uint64
+}
+
+func (s *roundRobinStrategy) Choose(candidates []*Upstream) *Upstream {
+	n := s.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobinStrategy 按权重平滑轮询：每次为所有候选加上各自的权重，
+// 选出当前值最大的一个并减去候选权重之和
+type weightedRoundRobinStrategy struct {
+	mu      sync.Mutex
+	current map[*Upstream]int
+}
+
+func (s *weightedRoundRobinStrategy) Choose(candidates []*Upstream) *Upstream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *Upstream
+	total := 0
+	for _, u := range candidates {
+		s.current[u] += u.Weight
+		total += u.Weight
+		if best == nil || s.current[u] > s.current[best] {
+			best = u
+		}
+	}
+	s.current[best] -= total
+	return best
+}
+
+// leastOutstandingStrategy 选择处理中请求最少的上游
+type leastOutstandingStrategy struct{}
+
+func (leastOutstandingStrategy) Choose(candidates []*Upstream) *Upstream {
+	best := candidates[0]
+	for _, u := range candidates[1:] {
+		if u.Outstanding() < best.Outstanding() {
+			best = u
+		}
+	}
+	return best
+}
+
+// lowestLatencyStrategy 选择延迟 EWMA 最低的上游。还没有延迟数据的上游排在最后，
+// 它们的延迟由健康检查测得，都没有数据时选择第一个候选
+type lowestLatencyStrategy struct{}
+
+func (lowestLatencyStrategy) Choose(candidates []*Upstream) *Upstream {
+	best := candidates[0]
+	for _, u := range candidates[1:] {
+		if fasterThan(u, best) {
+			best = u
+		}
+	}
+	return best
+}
+
+// fasterThan 判断 a 的延迟 EWMA 是否低于 b，没有延迟数据视为最慢
+func fasterThan(a, b *Upstream) bool {
+	la, lb := a.LatencyEWMA(), b.LatencyEWMA()
+	return la > 0 && (lb == 0 || la < lb)
+}
+
+// p2cStrategy 随机取两个候选，选择处理中请求较少的一个，相同时选择延迟较低的，没有延迟数据视为最慢
+type p2cStrategy struct{}
+
+func (p2cStrategy) Choose(candidates []*Upstream) *Upstream {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	switch {
+	case a.Outstanding() < b.Outstanding():
+		return a
+	case b.Outstanding() < a.Outstanding():
+		return b
+	case fasterThan(b, a):
+		return b
+	}
+	return a
+}
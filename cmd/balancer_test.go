@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// countChoices 用策略选择 n 次，返回每个上游被选中的次数
+func countChoices(s Strategy, candidates []*Upstream, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[s.Choose(candidates).URL]++
+	}
+	return counts
+}
+
+func TestStrategies(t *testing.T) {
+	a := &Upstream{URL: "a", Weight: 1}
+	b := &Upstream{URL: "b", Weight: 3}
+	candidates := []*Upstream{a, b}
+
+	if got := countChoices(failoverStrategy{}, candidates, 4); got["a"] != 4 {
+		t.Errorf("failover chose %v, want always the first candidate", got)
+	}
+	if got := countChoices(&roundRobinStrategy{}, candidates, 4); got["a"] != 2 || got["b"] != 2 {
+		t.Errorf("round-robin chose %v, want 2 each", got)
+	}
+	wrr := &weightedRoundRobinStrategy{current: make(map[*Upstream]int)}
+	if got := countChoices(wrr, candidates, 8); got["a"] != 2 || got["b"] != 6 {
+		t.Errorf("weighted-round-robin chose %v, want 2 and 6", got)
+	}
+
+	a.outstanding.Store(2)
+	if got := countChoices(leastOutstandingStrategy{}, candidates, 4); got["b"] != 4 {
+		t.Errorf("least-outstanding chose %v, want always b", got)
+	}
+	a.outstanding.Store(0)
+}
+
+// TestLowestLatencyUnknown 检查还没有延迟数据的上游不会被当成最快的
+func TestLowestLatencyUnknown(t *testing.T) {
+	fast := &Upstream{URL: "fast"}
+	slow := &Upstream{URL: "slow"}
+	unknown := &Upstream{URL: "unknown"}
+	fast.ObserveLatency(10 * time.Millisecond)
+	slow.ObserveLatency(100 * time.Millisecond)
+
+	tests := []struct {
+		candidates []*Upstream
+		want       string
+	}{
+		{[]*Upstream{unknown, slow, fast}, "fast"},
+		{[]*Upstream{unknown, slow}, "slow"},
+		{[]*Upstream{slow, unknown}, "slow"},
+		{[]*Upstream{unknown, &Upstream{URL: "other"}}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := (lowestLatencyStrategy{}).Choose(tt.candidates); got.URL != tt.want {
+			t.Errorf("lowest-latency chose %s, want %s", got.URL, tt.want)
+		}
+	}
+	// 处理中请求相同时 p2c 也不会因为没有数据而选中 unknown
+	if got := countChoices(p2cStrategy{}, []*Upstream{unknown, slow}, 20); got["unknown"] != 0 {
+		t.Errorf("p2c chose %v, want never unknown", got)
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	for name := range strategies {
+		if _, err := newStrategy(name); err != nil {
+			t.Errorf("newStrategy(%q) error = %v", name, err)
+		}
+	}
+	if _, err := newStrategy("random"); err == nil {
+		t.Error("newStrategy() accepted an unknown strategy")
+	}
+}
+
+// TestHealthCheckRecordsLatency 检查健康检查为还没有承接请求的上游提供延迟数据
+func TestHealthCheckRecordsLatency(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{"sync_info":{"latest_block_height":"10"}}}`)
+	})
+	pool := NewUpstreamPool([]string{upstream.URL})
+	pool.checkOnce(context.Background(), http.DefaultClient, HealthCheck{})
+	if pool.Upstreams()[0].LatencyEWMA() == 0 {
+		t.Error("health check recorded no latency")
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import "time"
+
+// BreakerState 是熔断器的状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常放行请求
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen 冷却结束，只放行少量探测请求
+	BreakerHalfOpen
+	// BreakerOpen 熔断中，不再选择该上游
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig 是熔断器的配置
+type BreakerConfig struct {
+	// ConsecutiveFailures 是连续失败多少次后熔断
+	ConsecutiveFailures int
+	// ErrorRatio 是统计窗口内错误率达到多少时熔断，请求数不少于 MinRequests 时才计算
+	ErrorRatio  float64
+	MinRequests int
+	Window      time.Duration
+	// Cooldown 是熔断后多久进入半开状态
+	Cooldown time.Duration
+	// HalfOpenProbes 是半开状态下每个冷却周期放行的探测请求数
+	HalfOpenProbes int
+}
+
+// defaultBreakerConfig 返回默认的熔断器配置
+func defaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ConsecutiveFailures: 5,
+		ErrorRatio:          0.5,
+		MinRequests:         20,
+		Window:              30 * time.Second,
+		Cooldown:            15 * time.Second,
+		HalfOpenProbes:      3,
+	}
+}
+
+// breaker 是单个上游的熔断器，由 Upstream.mu 保护
+type breaker struct {
+	state       BreakerState
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	changedAt   time.Time // 进入当前状态或开始当前探测周期的时间
+	probes      int       // 当前探测周期内已放行的探测请求数
+}
+
+// allow 判断是否可以向上游发送请求，半开状态下放行的请求计为探测
+func (b *breaker) allow(cfg BreakerConfig, now time.Time) bool {
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.changedAt) < cfg.Cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		// 探测请求的结果可能没有上报，每个冷却周期重新放行一批
+		if now.Sub(b.changedAt) >= cfg.Cooldown {
+			b.changedAt, b.probes = now, 0
+		}
+		if b.probes >= cfg.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// ready 判断熔断器是否允许请求，不占用探测名额
+func (b *breaker) ready(cfg BreakerConfig, now time.Time) bool {
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.changedAt) >= cfg.Cooldown
+	case BreakerHalfOpen:
+		return b.probes < cfg.HalfOpenProbes || now.Sub(b.changedAt) >= cfg.Cooldown
+	}
+	return true
+}
+
+// success 记录一次成功，半开状态下成功即恢复，返回状态是否变化
+func (b *breaker) success(cfg BreakerConfig, now time.Time) bool {
+	b.count(cfg, now, false)
+	b.consecutive = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed, now)
+		return true
+	}
+	return false
+}
+
+// failure 记录一次失败，连续失败或错误率过高时熔断，半开状态下失败立即重新熔断，返回状态是否变化
+func (b *breaker) failure(cfg BreakerConfig, now time.Time) bool {
+	b.count(cfg, now, true)
+	b.consecutive++
+	switch b.state {
+	case BreakerHalfOpen:
+		b.setState(BreakerOpen, now)
+		return true
+	case BreakerClosed:
+		tripped := cfg.ConsecutiveFailures > 0 && b.consecutive >= cfg.ConsecutiveFailures
+		if cfg.ErrorRatio > 0 && b.requests >= cfg.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= cfg.ErrorRatio {
+			tripped = true
+		}
+		if tripped {
+			b.setState(BreakerOpen, now)
+			return true
+		}
+	}
+	return false
+}
+
+// count 在统计窗口内计数，窗口过期后重新开始
+func (b *breaker) count(cfg BreakerConfig, now time.Time, failed bool) {
+	if now.Sub(b.windowStart) >= cfg.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+func (b *breaker) setState(state BreakerState, now time.Time) {
+	b.state = state
+	b.changedAt = now
+	b.probes = 0
+	if state != BreakerHalfOpen {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	if state == BreakerClosed {
+		b.consecutive = 0
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	consecutive := BreakerConfig{ConsecutiveFailures: 3, Window: time.Minute, Cooldown: 10 * time.Second, HalfOpenProbes: 1}
+	ratio := BreakerConfig{ErrorRatio: 0.5, MinRequests: 4, Window: time.Minute, Cooldown: 10 * time.Second, HalfOpenProbes: 1}
+
+	// step 是对熔断器的一次操作：wait 后执行 op，然后检查状态；op 为 allow 时同时检查是否放行
+	type step struct {
+		wait    time.Duration
+		op      string
+		want    BreakerState
+		allowed bool
+	}
+	tests := []struct {
+		name  string
+		cfg   BreakerConfig
+		steps []step
+	}{
+		{"consecutive failures open", consecutive, []step{
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerOpen},
+		}},
+		{"success resets consecutive failures", consecutive, []step{
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{op: "success", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+		}},
+		{"error ratio opens after min requests", ratio, []step{
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{op: "success", want: BreakerClosed},
+			{op: "failure", want: BreakerOpen},
+		}},
+		{"error ratio counts restart with the window", ratio, []step{
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+			{wait: time.Minute, op: "success", want: BreakerClosed},
+			{op: "success", want: BreakerClosed},
+			{op: "failure", want: BreakerClosed},
+		}},
+		{"open rejects until cooldown, then probes", consecutive, []step{
+			{op: "failure"}, {op: "failure"},
+			{op: "failure", want: BreakerOpen},
+			{op: "allow", want: BreakerOpen, allowed: false},
+			{wait: 10 * time.Second, op: "allow", want: BreakerHalfOpen, allowed: true},
+			{op: "allow", want: BreakerHalfOpen, allowed: false},
+			{wait: 10 * time.Second, op: "allow", want: BreakerHalfOpen, allowed: true},
+			{op: "success", want: BreakerClosed},
+			{op: "allow", want: BreakerClosed, allowed: true},
+		}},
+		{"failed probe reopens", consecutive, []step{
+			{op: "failure"}, {op: "failure"},
+			{op: "failure", want: BreakerOpen},
+			{wait: 10 * time.Second, op: "allow", want: BreakerHalfOpen, allowed: true},
+			{op: "failure", want: BreakerOpen},
+			{op: "allow", want: BreakerOpen, allowed: false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b breaker
+			now := time.Now()
+			for i, s := range tt.steps {
+				now = now.Add(s.wait)
+				switch s.op {
+				case "success":
+					b.success(tt.cfg, now)
+				case "failure":
+					b.failure(tt.cfg, now)
+				case "allow":
+					if got := b.allow(tt.cfg, now); got != s.allowed {
+						t.Fatalf("step %d: allow() = %v, want %v", i, got, s.allowed)
+					}
+				}
+				if b.state != s.want {
+					t.Fatalf("step %d (%s): state = %v, want %v", i, s.op, b.state, s.want)
+				}
+			}
+		})
+	}
+}
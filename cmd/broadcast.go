@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// fanoutMethods 是可以同时发往多个上游的交易广播方法，交易会进入每个上游的内存池
+var fanoutMethods = map[string]bool{
+	"broadcast_tx_sync":  true,
+	"broadcast_tx_async": true,
+}
+
+// duplicateTxError 是交易已在上游内存池中时 CheckTx 返回的错误
+const duplicateTxError = "tx already exists in cache"
+
+// 广播在单个上游上的结果，duplicate 表示交易已经在上游的内存池中，与 accepted 一样算成功
+const (
+	broadcastAccepted  = "accepted"
+	broadcastDuplicate = "duplicate"
+	broadcastRejected  = "rejected"
+	broadcastFailed    = "failed"
+)
+
+// fanoutable 判断请求是否扇出广播：开启了扇出，且所有调用都是 fanoutMethods 中的方法
+func (h *proxyHandler) fanoutable(rpc *RPCRequest) bool {
+	if h.opts.BroadcastFanout <= 1 || len(rpc.Calls) == 0 {
+		return false
+	}
+	for _, call := range rpc.Calls {
+		if !fanoutMethods[call.Method] {
+			return false
+		}
+	}
+	return true
+}
+
+// broadcastResult 返回一次广播在上游上的结果和说明，任一调用被拒绝即为 rejected，
+// 其余调用都通过 CheckTx 时为 accepted，其中有交易已在内存池中时为 duplicate
+func broadcastResult(resp *upstreamResponse, err error) (string, string) {
+	if err != nil {
+		return broadcastFailed, err.Error()
+	}
+
+	// CometBFT 拒绝交易时可能返回 500 和 JSON-RPC 错误，需要先解析响应体
+	var responses []RPCResponse
+	if err := json.Unmarshal(resp.Body, &responses); err != nil {
+		var single RPCResponse
+		if err := json.Unmarshal(resp.Body, &single); err != nil || (single.Error == nil && single.Result == nil) {
+			return broadcastFailed, fmt.Sprintf("status %d", resp.Status)
+		}
+		responses = []RPCResponse{single}
+	}
+	outcome := broadcastAccepted
+	var hashes []string
+	for _, r := range responses {
+		if r.Error != nil {
+			// 交易可能已经通过 P2P 传播到这个上游的内存池
+			if strings.Contains(r.Error.Data, duplicateTxError) {
+				outcome = broadcastDuplicate
+				hashes = append(hashes, r.Error.Data)
+				continue
+			}
+			return broadcastRejected, fmt.Sprintf("%s: %s", r.Error.Message, r.Error.Data)
+		}
+		var result struct {
+			Code uint32 `json:"code"`
+			Log  string `json:"log"`
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(r.Result, &result); err != nil {
+			return broadcastFailed, fmt.Sprintf("invalid result: %v", err)
+		}
+		if result.Code != 0 {
+			return broadcastRejected, fmt.Sprintf("code %d: %s", result.Code, result.Log)
+		}
+		hashes = append(hashes, result.Hash)
+	}
+	return outcome, strings.Join(hashes, ",")
+}
+
+// acceptDuplicates 把响应中交易已在内存池中的错误改写为通过 CheckTx 的结果，对客户端来说
+// 与广播成功相同。交易从请求参数中取出以计算哈希，无法取出时原样返回响应
+func acceptDuplicates(r *http.Request, body []byte, resp *upstreamResponse) *upstreamResponse {
+	rpc, err := parseRPCRequest(r, body)
+	if err != nil {
+		return resp
+	}
+	calls := make(map[string]RPCCall, len(rpc.Calls))
+	for _, call := range rpc.Calls {
+		calls[string(call.ID)] = call
+	}
+
+	// CometBFT 对只有一个调用的批量请求也可能返回单个对象，按响应体的形式解析
+	var responses []RPCResponse
+	batch := json.Unmarshal(resp.Body, &responses) == nil
+	if !batch {
+		var single RPCResponse
+		if err := json.Unmarshal(resp.Body, &single); err != nil {
+			return resp
+		}
+		responses = []RPCResponse{single}
+	}
+	for i, res := range responses {
+		if res.Error == nil || !strings.Contains(res.Error.Data, duplicateTxError) {
+			continue
+		}
+		call, ok := calls[string(res.ID)]
+		if len(rpc.Calls) == 1 {
+			call, ok = rpc.Calls[0], true
+		}
+		if !ok {
+			return resp
+		}
+		tx, ok := broadcastTx(r, call)
+		if !ok {
+			return resp
+		}
+		hash := sha256.Sum256(tx)
+		result, _ := json.Marshal(map[string]any{
+			"code": 0, "data": "", "log": "", "codespace": "",
+			"hash": strings.ToUpper(hex.EncodeToString(hash[:])),
+		})
+		responses[i] = RPCResponse{Jsonrpc: "2.0", ID: res.ID, Result: result}
+	}
+
+	var out []byte
+	if batch {
+		out, err = json.Marshal(responses)
+	} else {
+		out, err = json.Marshal(responses[0])
+	}
+	if err != nil {
+		return resp
+	}
+	header := resp.Header.Clone()
+	header.Set("Content-Type", "application/json")
+	return &upstreamResponse{Upstream: resp.Upstream, Status: http.StatusOK, Header: header, Body: out}
+}
+
+// broadcastTx 取出广播调用中的交易：JSON-RPC 调用中是 base64，URI 形式中是 0x 开头的十六进制或字符串
+func broadcastTx(r *http.Request, call RPCCall) ([]byte, bool) {
+	v, ok := call.Param("tx")
+	if !ok {
+		return nil, false
+	}
+	if r.Method == http.MethodGet {
+		if hexTx, ok := strings.CutPrefix(v, "0x"); ok {
+			tx, err := hex.DecodeString(hexTx)
+			return tx, err == nil
+		}
+		return []byte(v), true
+	}
+	tx, err := base64.StdEncoding.DecodeString(v)
+	return tx, err == nil
+}
+
+type broadcastOutcome struct {
+	upstream *Upstream
+	resp     *upstreamResponse
+	err      error
+}
+
+// forwardBroadcast 把交易同时广播到至多 BroadcastFanout 个健康的上游，返回第一个通过 CheckTx
+// 或交易已在内存池中的响应。其余上游的广播不会取消，完成后在后台记录结果；
+// 都没有通过时返回第一个 CheckTx 的拒绝结果
+func (h *proxyHandler) forwardBroadcast(r *http.Request, body []byte, methods string, rt route) (*upstreamResponse, error) {
+	logger := requestLogger(r.Context())
+	// 交易应进入尽量多的内存池，客户端断开或拿到结果后其他上游的广播继续完成
+	detached := r.WithContext(context.WithoutCancel(r.Context()))
+
+	var targets []*Upstream
+	for len(targets) < h.opts.BroadcastFanout {
+		upstream := h.pool.Pick(targets, rt)
+		// 第一个之后只广播到健康的上游
+		if upstream == nil || (len(targets) > 0 && !upstream.Healthy()) {
+			break
+		}
+		targets = append(targets, upstream)
+	}
+	if entry := accessEntryFrom(r.Context()); entry != nil {
+		entry.Attempts = len(targets)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("0 attempts failed, last error: no upstream available")
+	}
+
+	outcomes := make(chan broadcastOutcome, len(targets))
+	for i, upstream := range targets {
+		go func(n int, upstream *Upstream) {
+			resp, err := h.attempt(detached, upstream, body, methods, n)
+			outcomes <- broadcastOutcome{upstream: upstream, resp: resp, err: err}
+		}(i+1, upstream)
+	}
+	record := func(o broadcastOutcome) string {
+		result, detail := broadcastResult(o.resp, o.err)
+		logger.Info("Broadcast outcome", "upstream", o.upstream.URL, "methods", methods, "result", result, "detail", detail)
+		metricBroadcasts.WithLabelValues(o.upstream.URL, result).Inc()
+		return result
+	}
+
+	var rejected *upstreamResponse
+	var lastErr error
+	for received := 1; received <= len(targets); received++ {
+		o := <-outcomes
+		switch result := record(o); result {
+		case broadcastAccepted, broadcastDuplicate:
+			// 这里运行在请求的协程中，不能用 h.background：关闭时 WaitGroup.Wait 可能已经开始。
+			// 剩余的结果只用于日志和指标，关闭时不必等待
+			if remaining := len(targets) - received; remaining > 0 {
+				go func() {
+					for i := 0; i < remaining; i++ {
+						record(<-outcomes)
+					}
+				}()
+			}
+			if result == broadcastDuplicate {
+				return acceptDuplicates(r, body, o.resp), nil
+			}
+			return o.resp, nil
+		case broadcastFailed:
+			lastErr = fmt.Errorf("broadcast to %s failed", o.upstream.URL)
+			if o.err != nil {
+				lastErr = o.err
+			}
+		default:
+			if rejected == nil {
+				rejected = o.resp
+			}
+		}
+	}
+
+	if rejected != nil {
+		return rejected, nil
+	}
+	return nil, fmt.Errorf("%d attempts failed, last error: %v", len(targets), lastErr)
+}
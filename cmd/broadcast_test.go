@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBroadcastResult 检查广播结果的分类
+func TestBroadcastResult(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+		want string
+	}{
+		{"accepted", `{"jsonrpc":"2.0","id":1,"result":{"code":0,"hash":"AB"}}`, nil, broadcastAccepted},
+		{"check tx failed", `{"jsonrpc":"2.0","id":1,"result":{"code":5,"log":"insufficient funds"}}`, nil, broadcastRejected},
+		{"duplicate", `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"tx already exists in cache"}}`, nil, broadcastDuplicate},
+		{"rpc error", `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"mempool is full"}}`, nil, broadcastRejected},
+		{"batch with one rejection", `[{"jsonrpc":"2.0","id":1,"result":{"code":0}},{"jsonrpc":"2.0","id":2,"result":{"code":1}}]`, nil, broadcastRejected},
+		{"not json-rpc", `<html>bad gateway</html>`, nil, broadcastFailed},
+		{"request failed", ``, errors.New("connection refused"), broadcastFailed},
+	}
+	for _, tt := range tests {
+		resp := &upstreamResponse{Status: http.StatusOK, Body: []byte(tt.body)}
+		if got, detail := broadcastResult(resp, tt.err); got != tt.want {
+			t.Errorf("%s: result = %s (%s), want %s", tt.name, got, detail, tt.want)
+		}
+	}
+}
+
+// waitReceived 等待上游收到 n 个请求
+func waitReceived(t *testing.T, u *testUpstream, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(u.received()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("upstream received %d requests, want %d", len(u.received()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+const broadcastBody = `{"jsonrpc":"2.0","id":7,"method":"broadcast_tx_sync","params":{"tx":"AQI="}}`
+
+// TestBroadcastFanout 检查交易被同时广播到 BroadcastFanout 个上游，返回第一个通过 CheckTx 的响应
+func TestBroadcastFanout(t *testing.T) {
+	slow := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":7,"result":{"code":0,"hash":"SLOW"}}`)
+	})
+	fast := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":7,"result":{"code":0,"hash":"FAST"}}`)
+	})
+	unused := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{slow, fast, unused}, func(opts *proxyOptions) {
+		opts.BroadcastFanout = 2
+	})
+
+	start := time.Now()
+	w := serve(h, http.MethodPost, "/", broadcastBody)
+	if !strings.Contains(w.Body.String(), `"FAST"`) {
+		t.Fatalf("response = %s, want the first accepted result", w.Body)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("broadcast waited %v for the slow upstream", elapsed)
+	}
+	// 拿到结果后慢的上游的广播继续完成
+	waitReceived(t, slow, 1)
+	if n := len(unused.received()); n != 0 {
+		t.Errorf("broadcast went to %d upstreams beyond --broadcast-fanout", n+2)
+	}
+}
+
+// TestBroadcastDuplicate 检查交易已在内存池中时客户端得到带交易哈希的成功结果
+func TestBroadcastDuplicate(t *testing.T) {
+	duplicate := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"message":"Internal error","data":"tx already exists in cache"}}`)
+	}
+	h := newTestHandler(t, []*testUpstream{newTestUpstream(t, duplicate), newTestUpstream(t, duplicate)}, func(opts *proxyOptions) {
+		opts.BroadcastFanout = 2
+	})
+
+	w := serve(h, http.MethodPost, "/", broadcastBody)
+	hash := sha256.Sum256([]byte{1, 2})
+	want := strings.ToUpper(hex.EncodeToString(hash[:]))
+	if w.Code != http.StatusOK || rpcError(t, w.Body.Bytes()) != nil || !strings.Contains(w.Body.String(), want) {
+		t.Errorf("response = %d %s, want a successful result with hash %s", w.Code, w.Body, want)
+	}
+}
+
+// TestBroadcastRejected 检查所有上游都拒绝时返回 CheckTx 的拒绝结果
+func TestBroadcastRejected(t *testing.T) {
+	rejected := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":7,"result":{"code":5,"log":"insufficient funds"}}`)
+	})
+	failing := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	h := newTestHandler(t, []*testUpstream{failing, rejected}, func(opts *proxyOptions) {
+		opts.BroadcastFanout = 2
+	})
+
+	w := serve(h, http.MethodPost, "/", broadcastBody)
+	if !strings.Contains(w.Body.String(), "insufficient funds") {
+		t.Errorf("response = %d %s, want the CheckTx rejection", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"container/list"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tedtool/cmd/cache"
+)
+
+// heightPinnedMethods 是带 height 参数时结果不再变化的方法，不带 height 时返回最新高度的结果
+var heightPinnedMethods = map[string]bool{
+	"block":            true,
+	"block_results":    true,
+	"commit":           true,
+	"header":           true,
+	"validators":       true,
+	"consensus_params": true,
+}
+
+// hashPinnedMethods 是按哈希查询、结果不会变化的方法
+var hashPinnedMethods = map[string]bool{
+	"block_by_hash":  true,
+	"header_by_hash": true,
+	"tx":             true,
+}
+
+// latestMethods 是只返回最新状态、可以短时间缓存的方法
+var latestMethods = map[string]bool{
+	"status":     true,
+	"abci_info":  true,
+	"health":     true,
+	"net_info":   true,
+	"blockchain": true,
+}
+
+// normalizedKey 返回调用的缓存键，参数按名称排序，数字和字符串形式视为相同
+func normalizedKey(call RPCCall) string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(call.Params, &raw); err != nil {
+		return call.Method + "?" + string(call.Params)
+	}
+	params := make(map[string]string, len(raw))
+	for name := range raw {
+		if v, ok := call.Param(name); ok {
+			params[name] = v
+		}
+	}
+	return cache.Key(call.Method, params)
+}
+
+// pinnedHeight 返回调用固定的区块高度，没有固定高度时返回 0
+func pinnedHeight(call RPCCall) int64 {
+	v, ok := call.Param("height")
+	if !ok {
+		return 0
+	}
+	height, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || height <= 0 {
+		return 0
+	}
+	return height
+}
+
+// cachePolicy 返回调用的缓存键和有效期：有效期为 0 表示一直有效，不可缓存时返回 false
+func cachePolicy(call RPCCall, latestTTL time.Duration) (string, time.Duration, bool) {
+	key := normalizedKey(call)
+	switch {
+	case hashPinnedMethods[call.Method]:
+		return key, 0, true
+	case heightPinnedMethods[call.Method]:
+		if pinnedHeight(call) > 0 {
+			return key, 0, true
+		}
+		return key, latestTTL, latestTTL > 0
+	case latestMethods[call.Method]:
+		return key, latestTTL, latestTTL > 0
+	}
+	return "", 0, false
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // 零值表示不过期
+}
+
+// ResponseCache 是按字节数限制容量的 LRU 缓存，保存 JSON-RPC 调用的 result
+type ResponseCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List // 最近使用的在前
+	entries map[string]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewResponseCache 创建容量为 maxBytes 字节的缓存
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	return &ResponseCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get 返回未过期的缓存结果
+func (c *ResponseCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.hits.Add(1)
+			return e.value, true
+		}
+		c.removeLocked(el)
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put 保存结果，ttl 为 0 表示一直有效；超过容量八分之一的结果不缓存
+func (c *ResponseCache) Put(key string, value []byte, ttl time.Duration) {
+	size := int64(len(key) + len(value))
+	if size > c.maxBytes/8 {
+		return
+	}
+	e := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[key] = c.order.PushFront(e)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+func (c *ResponseCache) removeLocked(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.key) + len(e.value))
+}
+
+// Stats 返回命中次数、未命中次数、条目数和占用字节数
+func (c *ResponseCache) Stats() (hits, misses int64, entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits.Load(), c.misses.Load(), len(c.entries), c.bytes
+}
+
+// successResult 从单个调用的响应中取出 result，响应包含 error 或无法解析时返回 false
+func successResult(body []byte) (json.RawMessage, bool) {
+	var resp RPCResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+	if resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return nil, false
+	}
+	return resp.Result, true
+}
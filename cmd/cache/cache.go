@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/cobra"
+)
+
+// CacheCmd represents the cache command
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect, prune and pre-warm the on-disk block cache",
+	Long: `Manage the on-disk cache used by proxy and proxys with --disk-cache-dir.
+The cache only holds height-pinned and hash-pinned responses, which never change.`,
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Show entries, size and height range per method",
+	Run: func(cmd *cobra.Command, args []string) {
+		store := openStore(cmd)
+
+		type methodStats struct {
+			count      int
+			bytes      int64
+			minH, maxH int64
+		}
+		stats := make(map[string]*methodStats)
+		err := store.Walk(func(path string, e Entry) error {
+			st := stats[e.Method]
+			if st == nil {
+				st = &methodStats{}
+				stats[e.Method] = st
+			}
+			st.count++
+			st.bytes += int64(len(e.Result))
+			if e.Height > 0 && (st.minH == 0 || e.Height < st.minH) {
+				st.minH = e.Height
+			}
+			if e.Height > st.maxH {
+				st.maxH = e.Height
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to read cache: %v", err)
+		}
+
+		entries, bytes := store.Stats()
+		fmt.Printf("Entries: %d, size: %.1f MB\n", entries, float64(bytes)/(1<<20))
+		methods := make([]string, 0, len(stats))
+		for m := range stats {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			st := stats[m]
+			fmt.Printf("  %-20s entries: %d, size: %.1f MB, heights: %d-%d\n", m, st.count, float64(st.bytes)/(1<<20), st.minH, st.maxH)
+		}
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old entries by size or height",
+	Run: func(cmd *cobra.Command, args []string) {
+		maxSize, _ := cmd.Flags().GetInt("max-size")
+		below, _ := cmd.Flags().GetInt64("below-height")
+		store := openStore(cmd)
+
+		if below > 0 {
+			removed, err := store.PruneBelow(below)
+			if err != nil {
+				log.Fatalf("Failed to prune cache: %v", err)
+			}
+			fmt.Printf("Removed %d entries below height %d\n", removed, below)
+		}
+		if maxSize > 0 {
+			removed := store.Prune(int64(maxSize) << 20)
+			fmt.Printf("Removed %d least recently used entries\n", removed)
+		}
+		entries, bytes := store.Stats()
+		fmt.Printf("Entries: %d, size: %.1f MB\n", entries, float64(bytes)/(1<<20))
+	},
+}
+
+var warmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Pre-fetch height-pinned responses for a height range",
+	Run: func(cmd *cobra.Command, args []string) {
+		url, _ := cmd.Flags().GetString("url")
+		from, _ := cmd.Flags().GetInt64("from")
+		to, _ := cmd.Flags().GetInt64("to")
+		methods, _ := cmd.Flags().GetStringSlice("methods")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		if from <= 0 || to < from {
+			log.Fatalf("Invalid height range %d-%d", from, to)
+		}
+		store := openStore(cmd)
+		url = strings.TrimSuffix(url, "/")
+
+		// 并发拉取每个高度的每个方法
+		jobs := make(chan int64)
+		var stored, skipped, failed atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for height := range jobs {
+					for _, method := range methods {
+						params := map[string]string{"height": strconv.FormatInt(height, 10)}
+						key := Key(method, params)
+						if _, ok := store.Get(key); ok {
+							skipped.Add(1)
+							continue
+						}
+						result, err := fetch(url, method, params)
+						if err == nil && !Final(method, result) {
+							err = fmt.Errorf("result is not final yet")
+						}
+						if err == nil {
+							err = store.Put(key, method, height, result)
+						}
+						if err != nil {
+							log.Printf("Failed to warm %s at height %d: %v", method, height, err)
+							failed.Add(1)
+							continue
+						}
+						stored.Add(1)
+					}
+				}
+			}()
+		}
+		for h := from; h <= to; h++ {
+			jobs <- h
+		}
+		close(jobs)
+		wg.Wait()
+
+		fmt.Printf("Stored: %d, already cached: %d, failed: %d\n", stored.Load(), skipped.Load(), failed.Load())
+	},
+}
+
+// fetch 以 URI 形式调用节点，返回成功的 result
+func fetch(url, method string, params map[string]string) (json.RawMessage, error) {
+	var query []string
+	for name, value := range params {
+		query = append(query, name+"="+value)
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/%s?%s", url, method, strings.Join(query, "&")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var rpc struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &rpc); err != nil {
+		return nil, fmt.Errorf("status %d: %v", resp.StatusCode, err)
+	}
+	if len(rpc.Error) > 0 || len(rpc.Result) == 0 || string(rpc.Result) == "null" {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, rpc.Error)
+	}
+	return rpc.Result, nil
+}
+
+func openStore(cmd *cobra.Command) *DiskStore {
+	dir, _ := cmd.Flags().GetString("dir")
+	store, err := OpenDiskStore(dir, 0)
+	if err != nil {
+		log.Fatalf("Failed to open cache directory: %v", err)
+	}
+	return store
+}
+
+func init() {
+	// 定义命令行标志
+	CacheCmd.PersistentFlags().String("dir", "cache", "cache directory")
+
+	pruneCmd.Flags().Int("max-size", 0, "evict least recently used entries until the cache is at most this many MB")
+	pruneCmd.Flags().Int64("below-height", 0, "remove entries below this height")
+
+	warmCmd.Flags().String("url", "", "node URL to fetch from (required)")
+	warmCmd.Flags().Int64("from", 0, "first height to fetch")
+	warmCmd.Flags().Int64("to", 0, "last height to fetch")
+	warmCmd.Flags().StringSlice("methods", []string{"block", "block_results", "commit", "validators"}, "methods to fetch for each height")
+	warmCmd.Flags().Int("concurrency", 4, "number of concurrent requests")
+	warmCmd.MarkFlagRequired("url")
+
+	CacheCmd.AddCommand(inspectCmd, pruneCmd, warmCmd)
+}
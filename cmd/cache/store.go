@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key 返回方法和参数对应的缓存键，参数按名称排序，代理和预热使用同一个键
+func Key(method string, params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+params[name])
+	}
+	return method + "?" + strings.Join(parts, "&")
+}
+
+// Final 判断固定高度的结果是否已经不会再变化。最新高度的 commit 还没有被下一个区块确认，
+// canonical 为 false，其中的签名可能与最终提交的不同，代理和预热都不能长期缓存
+func Final(method string, result json.RawMessage) bool {
+	if method != "commit" {
+		return true
+	}
+	var commit struct {
+		Canonical bool `json:"canonical"`
+	}
+	if err := json.Unmarshal(result, &commit); err != nil {
+		return false
+	}
+	return commit.Canonical
+}
+
+// Entry 是磁盘上保存的一条不可变的响应结果
+type Entry struct {
+	Key    string          `json:"key"`
+	Method string          `json:"method"`
+	Height int64           `json:"height,omitempty"`
+	Stored time.Time       `json:"stored"`
+	Result json.RawMessage `json:"result"`
+}
+
+type fileInfo struct {
+	size    int64
+	touched time.Time
+}
+
+// DiskStore 将不可变的响应结果保存在目录中，每条结果一个文件，
+// 按方法分子目录。总大小超过上限时按最近访问时间淘汰
+type DiskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	files map[string]fileInfo // 文件路径 -> 大小和最近访问时间
+	bytes int64
+}
+
+// OpenDiskStore 打开缓存目录并统计已有文件，目录不存在时自动创建
+func OpenDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{dir: dir, maxBytes: maxBytes, files: make(map[string]fileInfo)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s.files[path] = fileInfo{size: info.Size(), touched: info.ModTime()}
+		s.bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// path 返回缓存键对应的文件路径
+func (s *DiskStore) path(key string) string {
+	method, _, _ := strings.Cut(key, "?")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, filepath.Base(method), hex.EncodeToString(sum[:])+".json")
+}
+
+// Get 读取缓存结果，并更新文件的访问时间
+func (s *DiskStore) Get(key string) (json.RawMessage, bool) {
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil || e.Key != key {
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	s.mu.Lock()
+	if info, ok := s.files[path]; ok {
+		info.touched = now
+		s.files[path] = info
+	}
+	s.mu.Unlock()
+	return e.Result, true
+}
+
+// Delete 删除一条缓存结果
+func (s *DiskStore) Delete(key string) {
+	path := s.path(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes -= s.files[path].size
+	delete(s.files, path)
+}
+
+// Put 保存一条结果，写入后总大小超过上限时淘汰最久未访问的文件
+func (s *DiskStore) Put(key, method string, height int64, result json.RawMessage) error {
+	data, err := json.Marshal(Entry{Key: key, Method: method, Height: height, Stored: time.Now().UTC(), Result: result})
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes -= s.files[path].size
+	s.files[path] = fileInfo{size: int64(len(data)), touched: time.Now()}
+	s.bytes += int64(len(data))
+	if s.maxBytes > 0 && s.bytes > s.maxBytes {
+		// 一次淘汰到上限的 90%，避免每次写入都排序
+		s.evictLocked(s.maxBytes * 9 / 10)
+	}
+	return nil
+}
+
+// Prune 淘汰最久未访问的文件，直到总大小不超过 maxBytes，返回删除的文件数
+func (s *DiskStore) Prune(maxBytes int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evictLocked(maxBytes)
+}
+
+func (s *DiskStore) evictLocked(target int64) int {
+	if s.bytes <= target {
+		return 0
+	}
+	paths := make([]string, 0, len(s.files))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return s.files[paths[i]].touched.Before(s.files[paths[j]].touched) })
+
+	removed := 0
+	for _, path := range paths {
+		if s.bytes <= target {
+			break
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		s.bytes -= s.files[path].size
+		delete(s.files, path)
+		removed++
+	}
+	return removed
+}
+
+// PruneBelow 删除高度低于 height 的结果，返回删除的文件数
+func (s *DiskStore) PruneBelow(height int64) (int, error) {
+	removed := 0
+	err := s.Walk(func(path string, e Entry) error {
+		if e.Height == 0 || e.Height >= height {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.bytes -= s.files[path].size
+		delete(s.files, path)
+		s.mu.Unlock()
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// Walk 依次读取所有结果
+func (s *DiskStore) Walk(fn func(path string, e Entry) error) error {
+	s.mu.Lock()
+	paths := make([]string, 0, len(s.files))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	s.mu.Unlock()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		if err := fn(path, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats 返回文件数和总字节数
+func (s *DiskStore) Stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files), s.bytes
+}
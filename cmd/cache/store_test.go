@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	a := Key("block", map[string]string{"height": "5", "b": "1"})
+	b := Key("block", map[string]string{"b": "1", "height": "5"})
+	if a != b || a != "block?b=1&height=5" {
+		t.Errorf("Key() = %q and %q", a, b)
+	}
+}
+
+func TestFinal(t *testing.T) {
+	tests := []struct {
+		method string
+		result string
+		want   bool
+	}{
+		{"block", `{}`, true},
+		{"commit", `{"canonical":true}`, true},
+		{"commit", `{"canonical":false}`, false},
+		{"commit", `[]`, false},
+	}
+	for _, tt := range tests {
+		if got := Final(tt.method, json.RawMessage(tt.result)); got != tt.want {
+			t.Errorf("Final(%s, %s) = %v, want %v", tt.method, tt.result, got, tt.want)
+		}
+	}
+}
+
+// TestDiskStorePersists 检查重新打开目录后能读到之前写入的结果
+func TestDiskStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key("block", map[string]string{"height": "5"})
+	if err := s.Put(key, "block", 5, json.RawMessage(`{"h":5}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.Get(key); !ok || string(got) != `{"h":5}` {
+		t.Errorf("Get() = %s, %v after reopening", got, ok)
+	}
+	if n, bytes := reopened.Stats(); n != 1 || bytes == 0 {
+		t.Errorf("Stats() = %d, %d", n, bytes)
+	}
+	if _, ok := reopened.Get(Key("block", map[string]string{"height": "6"})); ok {
+		t.Error("Get() returned a result that was never stored")
+	}
+
+	reopened.Delete(key)
+	if _, ok := reopened.Get(key); ok {
+		t.Error("deleted result still returned")
+	}
+	if n, bytes := reopened.Stats(); n != 0 || bytes != 0 {
+		t.Errorf("Stats() after delete = %d, %d", n, bytes)
+	}
+}
+
+// TestDiskStoreEvicts 检查超过上限时淘汰最久未访问的结果
+func TestDiskStoreEvicts(t *testing.T) {
+	s, err := OpenDiskStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) string { return Key("block", map[string]string{"height": fmt.Sprint(i)}) }
+	for i := 0; i < 10; i++ {
+		if err := s.Put(key(i), "block", int64(i+1), json.RawMessage(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.Get(key(0))
+
+	_, total := s.Stats()
+	if removed := s.Prune(total / 2); removed == 0 {
+		t.Fatal("Prune() removed nothing")
+	}
+	if _, ok := s.Get(key(0)); !ok {
+		t.Error("recently read result evicted")
+	}
+	if _, ok := s.Get(key(1)); ok {
+		t.Error("least recently used result kept")
+	}
+	if _, bytes := s.Stats(); bytes > total/2 {
+		t.Errorf("store holds %d bytes, want at most %d", bytes, total/2)
+	}
+
+	removed, err := s.PruneBelow(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(key(0)); ok || removed == 0 {
+		t.Errorf("PruneBelow() removed %d, result below the height kept", removed)
+	}
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tedtool/cmd/cache"
+)
+
+func TestNormalizedKey(t *testing.T) {
+	uri := func(target string) RPCCall {
+		rpc, err := parseRPCRequest(httptest.NewRequest(http.MethodGet, target, nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rpc.Calls[0]
+	}
+	body := func(method, params string) RPCCall {
+		return RPCCall{Method: method, Params: json.RawMessage(params)}
+	}
+	same := [][]RPCCall{
+		{uri("/block?height=5"), body("block", `{"height":"5"}`), body("block", `{"height":5}`)},
+		{uri(`/tx?hash="0xAB"&prove=true`), uri("/tx?prove=true&hash=0xAB"), body("tx", `{"prove":true,"hash":"0xAB"}`)},
+		{uri("/status"), body("status", `{}`), body("status", ``)},
+	}
+	for _, calls := range same {
+		for _, call := range calls[1:] {
+			if a, b := normalizedKey(calls[0]), normalizedKey(call); a != b {
+				t.Errorf("normalizedKey() = %q and %q, want the same key", a, b)
+			}
+		}
+	}
+	different := [][2]RPCCall{
+		{uri("/block?height=5"), uri("/block_results?height=5")},
+		{uri("/block?height=5"), uri("/block?height=6")},
+		{uri("/block?height=5"), body("block", `["5"]`)},
+	}
+	for _, pair := range different {
+		if a, b := normalizedKey(pair[0]), normalizedKey(pair[1]); a == b {
+			t.Errorf("normalizedKey() = %q for both calls", a)
+		}
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	tests := []struct {
+		method  string
+		params  string
+		wantTTL time.Duration
+		wantOK  bool
+	}{
+		{"block", `{"height":"5"}`, 0, true},
+		{"block", `{}`, time.Second, true},
+		{"block", `{"height":"0"}`, time.Second, true},
+		{"commit", `{"height":5}`, 0, true},
+		{"tx", `{"hash":"0xAB"}`, 0, true},
+		{"status", `{}`, time.Second, true},
+		{"abci_query", `{"path":"/store/bank/key"}`, 0, false},
+		{"broadcast_tx_sync", `{"tx":"AA=="}`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.params, func(t *testing.T) {
+			_, ttl, ok := cachePolicy(RPCCall{Method: tt.method, Params: json.RawMessage(tt.params)}, time.Second)
+			if ttl != tt.wantTTL || ok != tt.wantOK {
+				t.Errorf("cachePolicy() = %v, %v; want %v, %v", ttl, ok, tt.wantTTL, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	c := NewResponseCache(8 * 100)
+	c.Put("a", []byte(strings.Repeat("a", 90)), 0)
+	c.Put("b", []byte(strings.Repeat("b", 90)), time.Millisecond)
+	c.Put("big", []byte(strings.Repeat("x", 100)), 0)
+	if _, ok := c.Get("big"); ok {
+		t.Error("entry larger than an eighth of the cache was stored")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expired entry returned")
+	}
+
+	// 超过容量时淘汰最久未使用的条目
+	for i := 0; i < 8; i++ {
+		c.Get("a")
+		c.Put(fmt.Sprint(i), []byte(strings.Repeat("v", 90)), 0)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("recently used entry evicted")
+	}
+	if _, ok := c.Get("0"); ok {
+		t.Error("least recently used entry kept")
+	}
+	if _, _, _, bytes := c.Stats(); bytes > 800 {
+		t.Errorf("cache holds %d bytes, limit is 800", bytes)
+	}
+}
+
+// TestCacheKeyFollowsForwardedCall 检查缓存的结果总是上游实际执行的调用的结果
+func TestCacheKeyFollowsForwardedCall(t *testing.T) {
+	// 上游按路径或请求体中的方法返回结果
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		if method == "" {
+			var call RPCCall
+			json.NewDecoder(r.Body).Decode(&call)
+			method = call.Method
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"method":%q}}`, method)
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.CacheSize = 1 << 20
+		opts.CacheTTL = time.Minute
+	})
+
+	steps := []struct {
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantCache  string
+		wantResult string
+	}{
+		{http.MethodPost, "/block_results?height=5", `{"jsonrpc":"2.0","id":1,"method":"block","params":{"height":"5"}}`, http.StatusMethodNotAllowed, "", ""},
+		{http.MethodGet, "/block?height=5", "", http.StatusOK, "MISS", "block"},
+		{http.MethodPost, "/", `{"jsonrpc":"2.0","id":1,"method":"block","params":{"height":"5"}}`, http.StatusOK, "HIT", "block"},
+		{http.MethodGet, "/block_results?height=5", "", http.StatusOK, "MISS", "block_results"},
+		{http.MethodGet, "/", `{"jsonrpc":"2.0","id":1,"method":"block_results","params":{"height":5}}`, http.StatusOK, "HIT", "block_results"},
+		// HEAD 的响应没有结果，既不写入缓存也不参与合并
+		{http.MethodHead, "/header?height=5", "", http.StatusOK, "", ""},
+		{http.MethodGet, "/header?height=5", "", http.StatusOK, "MISS", "header"},
+	}
+	for i, s := range steps {
+		w := serve(h, s.method, s.target, s.body)
+		if w.Code != s.wantStatus {
+			t.Fatalf("step %d: status = %d, want %d: %s", i, w.Code, s.wantStatus, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != s.wantCache {
+			t.Errorf("step %d: X-Cache = %q, want %q", i, got, s.wantCache)
+		}
+		if s.wantResult == "" {
+			continue
+		}
+		var resp struct {
+			Result struct{ Method string }
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Result.Method != s.wantResult {
+			t.Errorf("step %d: response %s, want the result of %s", i, w.Body, s.wantResult)
+		}
+	}
+}
+
+// TestDiskCacheSurvivesRestart 检查重启后固定高度的结果从磁盘返回，不再请求上游
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	withDisk := func(opts *proxyOptions) {
+		disk, err := cache.OpenDiskStore(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.CacheSize = 1 << 20
+		opts.DiskCache = disk
+	}
+
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{"block":{"header":{"height":"5"}}}}`)
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, withDisk)
+	if w := serve(h, http.MethodGet, "/block?height=5", ""); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+	serve(h, http.MethodGet, "/status", "")
+
+	down := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	h = newTestHandler(t, []*testUpstream{down}, withDisk)
+	w := serve(h, http.MethodPost, "/", `{"jsonrpc":"2.0","id":7,"method":"block","params":{"height":"5"}}`)
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" || !strings.Contains(w.Body.String(), `"id":7`) {
+		t.Errorf("after restart: status = %d, X-Cache = %q: %s", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/status", ""); w.Header().Get("X-Cache") == "HIT" {
+		t.Error("latest-height result persisted to disk")
+	}
+	if n := len(down.received()); n != 1 {
+		t.Errorf("upstream received %d requests, want only the status call", n)
+	}
+}
@@ -0,0 +1,81 @@
+package certs
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// CertsCmd represents the certs command
+var CertsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Generate a development CA and certificates for TLS",
+	Long: `Generate a self-signed development CA and server or client certificates signed
+by it, for use with the --tls-cert, --tls-key and --tls-client-ca options of
+proxy and proxys. The CA is kept in --dir as ca.pem and ca-key.pem.
+These certificates are meant for development and internal use only.`,
+}
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Generate a self-signed CA",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		name, _ := cmd.Flags().GetString("name")
+		days, _ := cmd.Flags().GetInt("days")
+
+		if err := GenerateCA(dir, name, daysDuration(days)); err != nil {
+			log.Fatalf("Failed to generate CA: %v", err)
+		}
+		fmt.Printf("Wrote %s and %s\n", filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	},
+}
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Issue a server certificate signed by the CA",
+	Run: func(cmd *cobra.Command, args []string) {
+		issue(cmd, false)
+	},
+}
+
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Issue a client certificate signed by the CA, for mTLS",
+	Run: func(cmd *cobra.Command, args []string) {
+		issue(cmd, true)
+	},
+}
+
+func issue(cmd *cobra.Command, client bool) {
+	dir, _ := cmd.Flags().GetString("dir")
+	name, _ := cmd.Flags().GetString("name")
+	hosts, _ := cmd.Flags().GetStringSlice("hosts")
+	days, _ := cmd.Flags().GetInt("days")
+
+	if err := Issue(dir, name, hosts, client, daysDuration(days)); err != nil {
+		log.Fatalf("Failed to issue certificate: %v", err)
+	}
+	fmt.Printf("Wrote %s and %s\n", filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem"))
+}
+
+func daysDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func init() {
+	// 定义命令行标志
+	CertsCmd.PersistentFlags().String("dir", "certs", "directory for the CA and generated certificates")
+	CertsCmd.PersistentFlags().Int("days", 365, "validity in days")
+
+	caCmd.Flags().String("name", "tedtool dev CA", "common name of the CA")
+	serverCmd.Flags().String("name", "server", "common name and file name of the certificate")
+	serverCmd.Flags().StringSlice("hosts", []string{"localhost", "127.0.0.1"}, "DNS names and IPs the certificate is valid for")
+	clientCmd.Flags().String("name", "", "common name and file name of the certificate (required)")
+	clientCmd.MarkFlagRequired("name")
+
+	CertsCmd.AddCommand(caCmd, serverCmd, clientCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// isReadOnly 判断方法是否只读，只读调用可以合并、对冲和交叉校验，广播和运维接口不行
+func isReadOnly(method string) bool {
+	return !strings.HasPrefix(method, "broadcast_") &&
+		!strings.HasPrefix(method, "unsafe_") &&
+		!strings.HasPrefix(method, "dial_")
+}
+
+// flight 是一个正在进行的上游调用
+type flight struct {
+	done chan struct{}
+	resp *upstreamResponse
+	err  error
+}
+
+// Coalescer 将相同的并发请求合并为一次上游调用，结果分发给所有等待者
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	requests  atomic.Int64
+	coalesced atomic.Int64
+}
+
+// NewCoalescer 创建请求合并器
+func NewCoalescer() *Coalescer {
+	return &Coalescer{flights: make(map[string]*flight)}
+}
+
+// Do 执行 key 对应的调用；已有相同调用在进行时等待其结果，返回的 bool 表示结果是否来自其他请求
+func (c *Coalescer) Do(key string, fn func() (*upstreamResponse, error)) (*upstreamResponse, bool, error) {
+	c.requests.Add(1)
+
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		c.coalesced.Add(1)
+		<-f.done
+		return f.resp, true, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	f.resp, f.err = fn()
+
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	close(f.done)
+	return f.resp, false, f.err
+}
+
+// Stats 返回参与合并的请求数和被合并的请求数
+func (c *Coalescer) Stats() (requests, coalesced int64) {
+	return c.requests.Load(), c.coalesced.Load()
+}
+
+// withID 返回将响应 id 替换为 id 后的副本，响应不是单个 JSON-RPC 对象时原样返回
+func (resp *upstreamResponse) withID(id json.RawMessage) *upstreamResponse {
+	var fields map[string]json.RawMessage
+	if len(id) == 0 || json.Unmarshal(resp.Body, &fields) != nil {
+		return resp
+	}
+	if _, ok := fields["id"]; !ok {
+		return resp
+	}
+	fields["id"] = id
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return resp
+	}
+	out := *resp
+	out.Body = body
+	return &out
+}
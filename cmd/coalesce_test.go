@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescerDo(t *testing.T) {
+	c := NewCoalescer()
+	release := make(chan struct{})
+	var calls atomic.Int64
+	fn := func() (*upstreamResponse, error) {
+		calls.Add(1)
+		<-release
+		return &upstreamResponse{Status: http.StatusOK}, nil
+	}
+
+	var wg sync.WaitGroup
+	var shared atomic.Int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, s, _ := c.Do("block?height=5", fn); s {
+				shared.Add(1)
+			}
+		}()
+	}
+	// 等所有调用都进入等待后再返回结果
+	for {
+		if requests, _ := c.Stats(); requests == 10 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 || shared.Load() != 9 {
+		t.Errorf("fn called %d times, %d shared results; want 1 and 9", calls.Load(), shared.Load())
+	}
+
+	// 调用结束后相同的 key 会重新调用
+	c.Do("block?height=5", fn)
+	if calls.Load() != 2 {
+		t.Errorf("fn called %d times after the flight finished, want 2", calls.Load())
+	}
+}
+
+func TestWithID(t *testing.T) {
+	resp := &upstreamResponse{Body: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)}
+	var got RPCResponse
+	json.Unmarshal(resp.withID(json.RawMessage(`"abc"`)).Body, &got)
+	if string(got.ID) != `"abc"` {
+		t.Errorf("withID() id = %s", got.ID)
+	}
+	if string(resp.Body) != `{"jsonrpc":"2.0","id":1,"result":{}}` {
+		t.Error("withID() modified the shared response")
+	}
+	html := &upstreamResponse{Body: []byte(`<html></html>`)}
+	if html.withID(json.RawMessage(`2`)) != html {
+		t.Error("withID() changed a non JSON-RPC response")
+	}
+}
+
+// TestCoalesceRequests 检查相同的并发读请求只发往上游一次，每个客户端拿到自己的 id
+func TestCoalesceRequests(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var call RPCCall
+		json.NewDecoder(r.Body).Decode(&call)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"block":{}}}`, call.ID)
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.Coalesce = true
+	})
+
+	const clients = 5
+	var wg sync.WaitGroup
+	ids := make([]json.RawMessage, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := serve(h, http.MethodPost, "/", fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"block","params":{"height":"5"}}`, i))
+			var resp RPCResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			ids[i] = resp.ID
+		}(i)
+	}
+	for {
+		if requests, _ := h.coalescer.Stats(); requests == clients {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := len(upstream.received()); n != 1 {
+		t.Errorf("upstream received %d requests, want 1", n)
+	}
+	for i, id := range ids {
+		if string(id) != fmt.Sprint(i) {
+			t.Errorf("client %d got id %s", i, id)
+		}
+	}
+
+	// 写入类调用不合并
+	serve(h, http.MethodGet, "/broadcast_tx_sync?tx=0x00", "")
+	if requests, _ := h.coalescer.Stats(); requests != clients {
+		t.Error("broadcast call went through the coalescer")
+	}
+}
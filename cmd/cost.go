@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// MethodCost 是一个方法的基础开销及按参数放大的规则。
+// Scale 的键为参数名，值为该参数的基准值：开销 = Base * 参数值 / 基准值，且不低于 Base
+type MethodCost struct {
+	Base  float64            `json:"base"`
+	Scale map[string]float64 `json:"scale,omitempty"`
+}
+
+// CostTable 是按 JSON-RPC 方法计算开销的表，限流按开销单位扣减令牌
+type CostTable struct {
+	Default float64               `json:"default"`
+	Methods map[string]MethodCost `json:"methods"`
+}
+
+// defaultCostTable 是默认的开销表，分页查询以 CometBFT 默认的每页 30 条为基准
+func defaultCostTable() *CostTable {
+	return &CostTable{
+		Default: 1,
+		Methods: map[string]MethodCost{
+			"health":               {Base: 1},
+			"status":               {Base: 1},
+			"abci_info":            {Base: 1},
+			"net_info":             {Base: 2},
+			"block":                {Base: 2},
+			"block_by_hash":        {Base: 2},
+			"header":               {Base: 1},
+			"header_by_hash":       {Base: 1},
+			"commit":               {Base: 2},
+			"tx":                   {Base: 2},
+			"abci_query":           {Base: 2},
+			"check_tx":             {Base: 3},
+			"broadcast_tx_async":   {Base: 2},
+			"broadcast_tx_sync":    {Base: 2},
+			"broadcast_tx_commit":  {Base: 10},
+			"blockchain":           {Base: 3},
+			"block_results":        {Base: 5},
+			"consensus_params":     {Base: 1},
+			"consensus_state":      {Base: 5},
+			"dump_consensus_state": {Base: 20},
+			"genesis":              {Base: 50},
+			"genesis_chunked":      {Base: 10},
+			"validators":           {Base: 2, Scale: map[string]float64{"per_page": 30}},
+			"tx_search":            {Base: 5, Scale: map[string]float64{"per_page": 30}},
+			"block_search":         {Base: 5, Scale: map[string]float64{"per_page": 30}},
+			"unconfirmed_txs":      {Base: 5, Scale: map[string]float64{"limit": 30}},
+		},
+	}
+}
+
+// loadCostTable 读取 JSON 格式的开销表，文件中的方法覆盖默认表中的同名方法
+func loadCostTable(file string) (*CostTable, error) {
+	table := defaultCostTable()
+	if file == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var custom CostTable
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, err
+	}
+	if custom.Default > 0 {
+		table.Default = custom.Default
+	}
+	for method, cost := range custom.Methods {
+		// 负的开销会给令牌桶加令牌
+		if cost.Base < 0 {
+			return nil, fmt.Errorf("method %s: base cost must not be negative", method)
+		}
+		table.Methods[method] = cost
+	}
+	return table, nil
+}
+
+// paramLimits 是 CometBFT 对分页参数的上限，超过上限的值按上限执行，开销也按上限计算
+var paramLimits = map[string]float64{
+	"per_page": 100,
+	"limit":    100,
+}
+
+// Cost 返回单个调用的开销，放大开销的参数不是有限的非负数时返回错误
+func (t *CostTable) Cost(call RPCCall) (float64, error) {
+	mc, ok := t.Methods[call.Method]
+	if !ok {
+		return t.Default, nil
+	}
+
+	cost := mc.Base
+	for param, base := range mc.Scale {
+		v, ok := call.Param(param)
+		if !ok || base <= 0 {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+			return 0, fmt.Errorf("%s: %s must be a non-negative number, got %q", call.Method, param, v)
+		}
+		if limit, ok := paramLimits[param]; ok {
+			n = math.Min(n, limit)
+		}
+		cost *= math.Max(1, n/base)
+	}
+	return cost, nil
+}
+
+// RequestCost 返回整个请求的开销，不含 RPC 调用的请求按默认开销计算
+func (t *CostTable) RequestCost(req *RPCRequest) (float64, error) {
+	if len(req.Calls) == 0 {
+		return t.Default, nil
+	}
+	var total float64
+	for _, call := range req.Calls {
+		cost, err := t.Cost(call)
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+	return total, nil
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCost(t *testing.T) {
+	table := defaultCostTable()
+	tests := []struct {
+		method  string
+		params  string
+		want    float64
+		wantErr bool
+	}{
+		{"health", `{}`, 1, false},
+		{"unknown_method", `{}`, 1, false},
+		{"tx_search", `{}`, 5, false},
+		{"tx_search", `{"per_page":"30"}`, 5, false},
+		{"tx_search", `{"per_page":60}`, 10, false},
+		{"tx_search", `{"per_page":"10"}`, 5, false},
+		{"tx_search", `{"per_page":"0"}`, 5, false},
+		// CometBFT 每页最多返回 100 条，更大的值按 100 计算
+		{"tx_search", `{"per_page":"100"}`, 5 * 100 / 30.0, false},
+		{"tx_search", `{"per_page":"1e9"}`, 5 * 100 / 30.0, false},
+		{"unconfirmed_txs", `{"limit":"1000"}`, 5 * 100 / 30.0, false},
+		{"tx_search", `{"per_page":"NaN"}`, 0, true},
+		{"tx_search", `{"per_page":"Inf"}`, 0, true},
+		{"tx_search", `{"per_page":"-Inf"}`, 0, true},
+		{"tx_search", `{"per_page":"1e400"}`, 0, true},
+		{"tx_search", `{"per_page":"-1"}`, 0, true},
+		{"tx_search", `{"per_page":"many"}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.params, func(t *testing.T) {
+			got, err := table.Cost(RPCCall{Method: tt.method, Params: json.RawMessage(tt.params)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Cost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Cost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestCost(t *testing.T) {
+	table := defaultCostTable()
+	batch := &RPCRequest{Batch: true, Calls: []RPCCall{
+		{Method: "health"},
+		{Method: "block_results", Params: json.RawMessage(`{"height":"5"}`)},
+		{Method: "tx_search", Params: json.RawMessage(`{"per_page":"60"}`)},
+	}}
+	if got, err := table.RequestCost(batch); err != nil || got != 16 {
+		t.Errorf("RequestCost(batch) = %v, %v; want 16", got, err)
+	}
+	if got, err := table.RequestCost(&RPCRequest{}); err != nil || got != table.Default {
+		t.Errorf("RequestCost(no calls) = %v, %v; want the default cost", got, err)
+	}
+	batch.Calls = append(batch.Calls, RPCCall{Method: "validators", Params: json.RawMessage(`{"per_page":"NaN"}`)})
+	if _, err := table.RequestCost(batch); err == nil {
+		t.Error("RequestCost() accepted a batch with a NaN per_page")
+	}
+}
+
+func TestLoadCostTable(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	table, err := loadCostTable(write("costs.json", `{"default":2,"methods":{"status":{"base":3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Default != 2 || table.Methods["status"].Base != 3 || table.Methods["block"].Base != 2 {
+		t.Errorf("custom table not merged with the defaults: %+v", table)
+	}
+	if _, err := loadCostTable(write("negative.json", `{"methods":{"status":{"base":-100}}}`)); err == nil {
+		t.Error("negative base cost accepted")
+	}
+}
+
+// TestInvalidCostParamsRejected 检查 NaN 开销不能绕过限流：每个请求都被拒绝且不转发
+func TestInvalidCostParamsRejected(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.IPLimit = BucketConfig{Rate: 1, Burst: 1}
+	})
+	for i := 0; i < 10; i++ {
+		w := serve(h, http.MethodGet, "/tx_search?query=%22tx.height>1%22&per_page=NaN", "")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("request %d: status = %d, want 400", i, w.Code)
+		}
+	}
+	if len(upstream.received()) > 0 {
+		t.Fatalf("upstream received %q", upstream.received())
+	}
+}
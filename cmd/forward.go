@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hopHeaders 是只对单跳连接有效的头部，转发时需要去掉（RFC 7230 6.1）
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders 删除逐跳头部以及 Connection 中列出的头部
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// upstreamURL 将客户端请求的路径和查询参数拼接到上游地址上
+func upstreamURL(targetURL string, r *http.Request) (string, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return "", err
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + r.URL.Path
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+	return target.String(), nil
+}
+
+// newUpstreamRequest 根据客户端请求构建发往上游的请求，body 为已缓存的请求体
+func newUpstreamRequest(ctx context.Context, targetURL string, r *http.Request, body []byte) (*http.Request, error) {
+	u, err := upstreamURL(targetURL, r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL %s: %v", targetURL, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// 复制请求头并去掉逐跳头部
+	req.Header = r.Header.Clone()
+	removeHopHeaders(req.Header)
+	// 由 Transport 负责压缩协商，响应体总是解压后的内容
+	req.Header.Del("Accept-Encoding")
+
+	// 追加客户端地址到 X-Forwarded-For
+	if clientIP := clientAddr(r); clientIP != "" {
+		if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if r.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	return req, nil
+}
+
+// clientAddr 返回与代理直接相连的客户端 IP
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// parseCIDRs 解析 CIDR 列表，单个 IP 按 /32 或 /128 处理
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipTrusted(trusted []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realClientIP 返回真实的客户端 IP：直连地址属于可信代理时，
+// 从 X-Forwarded-For 的右侧向左取第一个不可信的地址
+func realClientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := clientAddr(r)
+	if !ipTrusted(trusted, ip) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !ipTrusted(trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamURL(t *testing.T) {
+	tests := []struct {
+		upstream string
+		target   string
+		want     string
+	}{
+		{"http://node:26657", "/status", "http://node:26657/status"},
+		{"http://node:26657/", "/block?height=5", "http://node:26657/block?height=5"},
+		{"https://rpc.example.com/cosmos/", "/abci_query?path=%22/store%22", "https://rpc.example.com/cosmos/abci_query?path=%22/store%22"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		if got, err := upstreamURL(tt.upstream, r); err != nil || got != tt.want {
+			t.Errorf("upstreamURL(%q, %q) = %q, %v; want %q", tt.upstream, tt.target, got, err, tt.want)
+		}
+	}
+}
+
+// TestFailover 检查上游失败时请求在下一个上游上重放
+func TestFailover(t *testing.T) {
+	down := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	})
+	up := newTestUpstream(t, nil)
+	retry := func(opts *proxyOptions) { opts.Retries = 2 }
+	h := newTestHandler(t, []*testUpstream{down, up}, retry)
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"status"}`
+	w := serve(h, http.MethodPost, "/", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 from the second upstream: %s", w.Code, w.Body)
+	}
+	if got := up.received(); len(got) != 1 || got[0] != "POST / "+body {
+		t.Errorf("second upstream received %q, want the replayed request", got)
+	}
+
+	// 非 200 的 JSON-RPC 错误换上游重试，最后一次尝试时原样返回
+	rpcErr := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error","data":"height 5 is not available"}}`)
+	})
+	other := newTestUpstream(t, nil)
+	h = newTestHandler(t, []*testUpstream{rpcErr, other}, retry)
+	if w := serve(h, http.MethodGet, "/block?height=5", ""); w.Code != http.StatusOK || len(other.received()) != 1 {
+		t.Errorf("JSON-RPC error not retried: status = %d: %s", w.Code, w.Body)
+	}
+	h = newTestHandler(t, []*testUpstream{rpcErr}, retry)
+	if w := serve(h, http.MethodGet, "/block?height=5", ""); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "not available") {
+		t.Errorf("JSON-RPC error on the last attempt: status = %d: %s", w.Code, w.Body)
+	}
+
+	h = newTestHandler(t, []*testUpstream{down}, retry)
+	if w := serve(h, http.MethodGet, "/status", ""); w.Code != http.StatusBadGateway || rpcError(t, w.Body.Bytes()).Code != codeUpstreamsFailed {
+		t.Errorf("all upstreams failed: status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{upstream}, nil)
+	body := `{"jsonrpc":"2.0","id":1,"method":"broadcast_tx_sync","params":{"tx":"` + strings.Repeat("A", maxRequestBodyBytes) + `"}}`
+	w := serve(h, http.MethodPost, "/", body)
+	if w.Code != http.StatusRequestEntityTooLarge || rpcError(t, w.Body.Bytes()).Code != codeRequestTooLarge {
+		t.Errorf("status = %d, want 413: %.200s", w.Code, w.Body)
+	}
+	if len(upstream.received()) > 0 {
+		t.Error("oversized request forwarded")
+	}
+}
+
+// TestResponseTooLarge 检查超过上限的上游响应不会被完整读入内存，也不计为上游失败
+func TestResponseTooLarge(t *testing.T) {
+	defer func(n int) { maxResponseBodyBytes = n }(maxResponseBodyBytes)
+	maxResponseBodyBytes = 1024
+
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"data":"%s"}}`, strings.Repeat("A", 4096))
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, nil)
+	if w := serve(h, http.MethodGet, "/block?height=5", ""); w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", w.Code)
+	}
+	if !h.pool.Upstreams()[0].Healthy() || h.pool.Upstreams()[0].breaker.consecutive != 0 {
+		t.Error("oversized response counted as an upstream failure")
+	}
+}
+
+// TestForwardHeaders 检查查询参数和请求头被转发，逐跳头部在两个方向上都被去掉
+func TestForwardHeaders(t *testing.T) {
+	var got http.Header
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("X-Upstream", "node")
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{}}`)
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/tx_search?query=%22tx.height%3E5%22&per_page=10", nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("Connection", "X-Client-Hop")
+	r.Header.Set("X-Client-Hop", "1")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if req := upstream.received(); len(req) != 1 || req[0] != "GET /tx_search?query=%22tx.height%3E5%22&per_page=10" {
+		t.Errorf("upstream received %q, want the query string kept", req)
+	}
+	if got.Get("Authorization") != "Bearer token" {
+		t.Error("end-to-end header not forwarded")
+	}
+	for _, name := range []string{"X-Client-Hop", "Keep-Alive"} {
+		if got.Get(name) != "" {
+			t.Errorf("hop-by-hop header %s forwarded", name)
+		}
+	}
+	if xff := got.Get("X-Forwarded-For"); xff != "203.0.113.1, 198.51.100.7" {
+		t.Errorf("X-Forwarded-For = %q", xff)
+	}
+	if got.Get("X-Forwarded-Proto") != "http" || got.Get(requestIDHeader) == "" {
+		t.Errorf("forwarded headers = %v", got)
+	}
+	if w.Header().Get("X-Upstream-Hop") != "" || w.Header().Get("X-Upstream") != "node" {
+		t.Errorf("response headers = %v, want hop-by-hop headers removed", w.Header())
+	}
+}
+
+func TestRealClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"198.51.100.7:1", "203.0.113.1", "198.51.100.7"},
+		{"10.0.0.1:1", "203.0.113.1", "203.0.113.1"},
+		{"10.0.0.1:1", "1.1.1.1, 203.0.113.1, 192.0.2.1", "203.0.113.1"},
+		{"10.0.0.1:1", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := realClientIP(r, trusted); got != tt.want {
+			t.Errorf("realClientIP(%s, %q) = %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
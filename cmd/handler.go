@@ -0,0 +1,544 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tedtool/cmd/cache"
+	"tedtool/cmd/lightclient"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxRequestBodyBytes 限制缓存的请求体大小
+const maxRequestBodyBytes = 10 << 20
+
+// maxResponseBodyBytes 限制读入内存的上游响应大小，足以容纳最大区块的 block 和 block_results
+var maxResponseBodyBytes = 128 << 20
+
+// errResponseTooLarge 表示上游响应超过 maxResponseBodyBytes，换一个上游也不会变小，不计为上游失败
+var errResponseTooLarge = errors.New("upstream response too large")
+
+// proxyHandler 是 proxy 和 proxys 共用的反向代理处理器
+type proxyHandler struct {
+	pool      *UpstreamPool
+	client    *http.Client
+	limiter   *RateLimiter
+	opts      proxyOptions
+	cache     *ResponseCache
+	disk      *cache.DiskStore
+	coalescer *Coalescer
+	ws        *wsHub
+	latencies *latencyWindow
+	verifier  *lightclient.Verifier
+
+	// ctx 是后台任务的 context，在 stop 时取消。后台任务要在处理中的请求完成后才退出，
+	// 不能跟随退出信号，否则用量等状态会在请求结束前写入
+	ctx    context.Context
+	cancel context.CancelFunc
+	// tasks 跟踪后台任务，关闭时等待它们退出
+	tasks sync.WaitGroup
+}
+
+// newProxyHandler 创建代理处理器，ctx 只用于初始化，后台任务运行到 stop 被调用为止
+func newProxyHandler(ctx context.Context, pool *UpstreamPool, opts proxyOptions) *proxyHandler {
+	bg, cancel := context.WithCancel(context.Background())
+	h := &proxyHandler{
+		pool: pool,
+		client: &http.Client{
+			// 重定向原样返回给客户端，不由代理跟随
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		limiter:   NewRateLimiter(bg, opts.QueueTimeout, opts.QueueSize),
+		opts:      opts,
+		ws:        newWSHub(pool, opts.WSMaxSubs),
+		latencies: newLatencyWindow(latencySamples),
+		ctx:       bg,
+		cancel:    cancel,
+	}
+	if opts.Verify != nil {
+		var err error
+		if h.verifier, err = newVerifier(ctx, pool, *opts.Verify, opts.UpstreamTimeout); err != nil {
+			log.Fatalf("Failed to initialize light client: %v", err)
+		}
+		log.Printf("Light client verification enabled, trusted height %d", opts.Verify.TrustedHeight)
+	}
+	if opts.Keys != nil {
+		h.background(func(ctx context.Context) { opts.Keys.Watch(ctx, 10*time.Second) })
+	}
+	if opts.Coalesce {
+		h.coalescer = NewCoalescer()
+	}
+	if opts.CacheSize > 0 {
+		h.cache = NewResponseCache(opts.CacheSize)
+		h.disk = opts.DiskCache
+	}
+	h.background(func(ctx context.Context) { h.logStats(ctx, time.Minute) })
+	if opts.AdminPort > 0 {
+		h.background(func(ctx context.Context) { serveMetrics(ctx, opts.AdminPort) })
+	}
+	return h
+}
+
+// background 在后台运行一个任务，任务应在 ctx 结束时退出，stop 会等待它退出
+func (h *proxyHandler) background(fn func(ctx context.Context)) {
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		fn(h.ctx)
+	}()
+}
+
+// stop 取消后台任务并等待它们退出
+func (h *proxyHandler) stop() {
+	h.cancel()
+	h.tasks.Wait()
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metricInflight.Inc()
+	defer metricInflight.Dec()
+	start := time.Now()
+	client := h.newClientInfo(r)
+
+	// 沿用或生成请求 ID，同时返回给客户端并转发给上游，请求结束时输出访问日志
+	entry := &accessEntry{ID: requestID(r), Client: client}
+	r.Header.Set(requestIDHeader, entry.ID)
+	w.Header().Set(requestIDHeader, entry.ID)
+	r = r.WithContext(withAccessEntry(r.Context(), entry))
+	rec := &accessRecorder{ResponseWriter: w}
+	w = rec
+	defer logAccess(entry, rec, start)
+
+	// WebSocket 连接单独处理
+	if websocket.IsWebSocketUpgrade(r) {
+		if err := h.admit(r.Context(), client, nil, h.opts.Costs.Default); err != nil {
+			entry.Error = err.Error()
+			metricRejections.WithLabelValues(err.reason).Inc()
+			err.write(w, nil)
+			return
+		}
+		h.serveWebSocket(w, r, client)
+		return
+	}
+
+	// 缓存请求体，便于失败时在其他上游上重放
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		entry.Error = err.Error()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeRPCErrors(w, http.StatusRequestEntityTooLarge, nil, codeRequestTooLarge, "Request body too large", err.Error())
+			return
+		}
+		writeRPCErrors(w, http.StatusBadRequest, nil, codeInvalidRequest, "Failed to read request body", err.Error())
+		return
+	}
+
+	// 解析 JSON-RPC 调用，按所有调用的开销之和限流
+	rpc, err := parseRPCRequest(r, body)
+	if errors.Is(err, errMethodPathVerb) {
+		entry.Error = err.Error()
+		metricRejections.WithLabelValues("policy").Inc()
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		writeRPCErrors(w, http.StatusMethodNotAllowed, nil, codeInvalidRequest, "HTTP method not allowed", err.Error())
+		return
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		metricRejections.WithLabelValues("parse_error").Inc()
+		writeRPCErrors(w, http.StatusBadRequest, nil, codeParseError, "Parse error", err.Error())
+		return
+	}
+	if h.opts.MaxBatch > 0 && len(rpc.Calls) > h.opts.MaxBatch {
+		entry.Error = fmt.Sprintf("batch of %d calls exceeds the limit of %d", len(rpc.Calls), h.opts.MaxBatch)
+		metricRejections.WithLabelValues("batch_too_large").Inc()
+		writeRPCErrors(w, http.StatusRequestEntityTooLarge, nil, codeRequestTooLarge, "Batch too large", entry.Error)
+		return
+	}
+	methods := strings.Join(rpc.Methods(), ",")
+	entry.Methods = methods
+	cost, err := h.opts.Costs.RequestCost(rpc)
+	if err != nil {
+		entry.Error = err.Error()
+		metricRejections.WithLabelValues("invalid_params").Inc()
+		writeRPCErrors(w, http.StatusBadRequest, rpc, codeInvalidParams, "Invalid params", err.Error())
+		h.observe(rpc, "none", http.StatusBadRequest, start)
+		return
+	}
+	if err := h.admit(r.Context(), client, rpc.Calls, cost); err != nil {
+		entry.Error = err.Error()
+		metricRejections.WithLabelValues(err.reason).Inc()
+		err.write(w, rpc)
+		h.observe(rpc, "none", err.status, start)
+		return
+	}
+
+	source, status := h.serveRPC(w, r, rpc, body, methods)
+	entry.Upstream = source
+	h.observe(rpc, source, status, start)
+}
+
+// serveRPC 依次查缓存、合并相同请求并转发到上游，返回响应的来源和状态码
+func (h *proxyHandler) serveRPC(w http.ResponseWriter, r *http.Request, rpc *RPCRequest, body []byte, methods string) (string, int) {
+	rt := route{Need: requestRequiredHeight(rpc)}
+	minHeight, err := parseMinHeight(r)
+	if err != nil {
+		writeRPCErrors(w, http.StatusBadRequest, rpc, codeInvalidRequest, "Invalid "+minHeightHeader+" header", err.Error())
+		return "none", http.StatusBadRequest
+	}
+	rt.MinLatest = minHeight
+	// 读取最新状态的调用只发往不低于已返回过的最高高度（允许落后 MaxReadLag 个区块）的上游，
+	// 避免先后两次查询看到的高度倒退
+	if rt.Need == 0 && h.opts.MaxReadLag >= 0 && readsLatestState(rpc) {
+		if floor := h.pool.ServedHeight() - h.opts.MaxReadLag; floor > rt.MinLatest {
+			rt.MinLatest = floor
+		}
+	}
+
+	// 开启验证时只返回验证过的结果，路由列表和 CORS 预检这类不含调用的请求没有可验证的内容
+	if h.verifier != nil && len(rpc.Calls) == 0 {
+		writeRPCErrors(w, http.StatusBadRequest, rpc, codeVerificationFailed, "Request cannot be verified", "requests without RPC calls are not served when verification is enabled")
+		return "none", http.StatusBadRequest
+	}
+
+	// 单个调用先查缓存，要求最低高度的请求不使用缓存，也不与其他请求合并。
+	// 缓存键和合并键来自解析出的调用，它与上游按路径或请求体执行的调用一致
+	shareable := carriesResult(r) && !rpc.Batch && len(rpc.Calls) == 1 && minHeight == 0
+	var cacheKey string
+	var cacheTTL time.Duration
+	cacheable := false
+	if h.cache != nil && shareable {
+		cacheKey, cacheTTL, cacheable = cachePolicy(rpc.Calls[0], h.opts.CacheTTL)
+	}
+	if cacheable {
+		// 低于高度下限的最新状态不从缓存返回
+		result, ok := h.cachedResult(r.Context(), rpc.Calls[0], cacheKey, cacheTTL)
+		if ok && rt.MinLatest > 0 && latestHeight(rpc.Calls[0], result) < rt.MinLatest {
+			ok = false
+		}
+		if ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeRPCResult(w, rpc.Calls[0].ID, result)
+			return "cache", http.StatusOK
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	// 固定了高度的调用只发往保留了该高度的上游，没有上游保留时直接返回错误
+	if err := h.pool.CheckHeight(rt.Need); err != nil {
+		if entry := accessEntryFrom(r.Context()); entry != nil {
+			entry.Error = err.Error()
+		}
+		writeRPCErrors(w, http.StatusNotFound, rpc, codeHeightUnavailable, "Height not available", err.Error())
+		return "none", http.StatusNotFound
+	}
+
+	// 有最新高度下限时等待上游达到该高度
+	if rt.MinLatest > 0 {
+		if err := h.waitForHeight(r.Context(), rt); err != nil {
+			if entry := accessEntryFrom(r.Context()); entry != nil {
+				entry.Error = err.Error()
+			}
+			writeRPCErrors(w, http.StatusServiceUnavailable, rpc, codeHeightNotReached, "Height not reached", err.Error())
+			return "none", http.StatusServiceUnavailable
+		}
+	}
+
+	// 需要交叉验证的请求发往多个上游并比较结果，否则开启对冲时只读请求在上游响应慢时同时发往第二个上游；
+	// 开启扇出时交易广播同时发往多个上游
+	forward := h.forward
+	switch {
+	case h.fanoutable(rpc):
+		forward = h.forwardBroadcast
+	case h.quorumable(rpc):
+		forward = h.forwardQuorum
+	case h.hedgeable(rpc):
+		forward = h.forwardHedged
+	}
+
+	// 只读的单个调用合并相同的并发请求，发起请求的客户端断开不影响其他等待者
+	var resp *upstreamResponse
+	if h.coalescer != nil && shareable && isReadOnly(rpc.Calls[0].Method) {
+		call := rpc.Calls[0]
+		detached := r.WithContext(context.WithoutCancel(r.Context()))
+		var shared bool
+		resp, shared, err = h.coalescer.Do(normalizedKey(call), func() (*upstreamResponse, error) {
+			return forward(detached, body, methods, rt)
+		})
+		metricCoalesce.WithLabelValues(strconv.FormatBool(shared)).Inc()
+		if shared && resp != nil {
+			resp = resp.withID(call.ID)
+		}
+	} else {
+		resp, err = forward(r, body, methods, rt)
+	}
+	if err != nil {
+		if entry := accessEntryFrom(r.Context()); entry != nil {
+			entry.Error = err.Error()
+		}
+		var qerr *quorumError
+		if errors.As(err, &qerr) {
+			writeRPCErrors(w, http.StatusBadGateway, rpc, codeQuorumFailed, "Upstreams disagree", err.Error())
+			return "none", http.StatusBadGateway
+		}
+		writeRPCErrors(w, http.StatusBadGateway, rpc, codeUpstreamsFailed, "All upstreams failed", err.Error())
+		return "none", http.StatusBadGateway
+	}
+
+	// 开启验证时拒绝没有通过轻客户端验证的响应，任何状态码的响应都要验证，只有验证过的结果会进入缓存
+	if h.verifier != nil {
+		if err := h.verifyResponse(r.Context(), resp.Upstream.URL, rpc, resp.Body); err != nil {
+			requestLogger(r.Context()).Warn("Response verification failed", "upstream", resp.Upstream.URL, "error", err)
+			if entry := accessEntryFrom(r.Context()); entry != nil {
+				entry.Error = err.Error()
+			}
+			writeRPCErrors(w, http.StatusBadGateway, rpc, codeVerificationFailed, "Response verification failed", err.Error())
+			return resp.Upstream.URL, http.StatusBadGateway
+		}
+	}
+
+	h.recordServed(rpc, resp)
+	// 只缓存成功的结果，错误永远不缓存
+	if cacheable && resp.Status == http.StatusOK {
+		if result, ok := successResult(resp.Body); ok {
+			h.storeResult(cacheKey, cacheTTL, rpc.Calls[0], result)
+		}
+	}
+	resp.write(w)
+	return resp.Upstream.URL, resp.Status
+}
+
+// carriesResult 判断上游对该请求的响应体是否是调用结果。HEAD 的响应没有响应体，
+// 开启了 CORS 的上游会直接应答 OPTIONS 预检，这些响应不能缓存，也不能与其他请求共享
+func carriesResult(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodPost
+}
+
+// observe 按调用记录请求数和耗时指标，批量请求中的每个调用分别计数
+func (h *proxyHandler) observe(rpc *RPCRequest, source string, status int, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	statusLabel := strconv.Itoa(status)
+	for _, call := range rpc.Calls {
+		method := h.metricMethod(call.Method)
+		metricRequests.WithLabelValues(method, source, statusLabel).Inc()
+		metricRequestDuration.WithLabelValues(method, source).Observe(elapsed)
+	}
+}
+
+// cachedResult 先查内存缓存，不可变的结果再查磁盘缓存，磁盘命中时放回内存。
+// 磁盘缓存可能由未开启验证的代理或 cache warm 写入，开启验证时磁盘命中的结果先验证再使用，
+// 内存缓存中只有验证过的结果
+func (h *proxyHandler) cachedResult(ctx context.Context, call RPCCall, key string, ttl time.Duration) (json.RawMessage, bool) {
+	if result, ok := h.cache.Get(key); ok {
+		metricCache.WithLabelValues("hit").Inc()
+		return result, true
+	}
+	if h.disk != nil && ttl == 0 {
+		if result, ok := h.disk.Get(key); ok {
+			if h.verifier != nil {
+				if err := h.verifyResult(ctx, "", call, result); err != nil {
+					requestLogger(ctx).Warn("Disk cache entry failed verification", "key", key, "error", err)
+					h.disk.Delete(key)
+					metricCache.WithLabelValues("miss").Inc()
+					return nil, false
+				}
+			}
+			metricCache.WithLabelValues("disk_hit").Inc()
+			h.cache.Put(key, result, 0)
+			return result, true
+		}
+	}
+	metricCache.WithLabelValues("miss").Inc()
+	return nil, false
+}
+
+// storeResult 保存成功的结果，不可变的结果同时写入磁盘缓存。
+// 固定了高度但还会变化的结果（例如最新高度的 commit）不缓存
+func (h *proxyHandler) storeResult(key string, ttl time.Duration, call RPCCall, result json.RawMessage) {
+	if ttl == 0 && !cache.Final(call.Method, result) {
+		return
+	}
+	h.cache.Put(key, result, ttl)
+	if h.disk == nil || ttl != 0 {
+		return
+	}
+	if err := h.disk.Put(key, call.Method, pinnedHeight(call), result); err != nil {
+		log.Printf("Error writing disk cache: %v", err)
+	}
+}
+
+// upstreamResponse 是已完整读取的上游响应
+type upstreamResponse struct {
+	Upstream *Upstream
+	Status   int
+	Header   http.Header
+	Body     []byte
+}
+
+// write 将上游响应的头部、状态码和响应体写回客户端
+func (resp *upstreamResponse) write(w http.ResponseWriter) {
+	header := resp.Header.Clone()
+	removeHopHeaders(header)
+	header.Del("Content-Length")
+	for k, vv := range header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.Status)
+	if _, err := w.Write(resp.Body); err != nil {
+		log.Printf("Error writing response body: %v", err)
+	}
+}
+
+// failed 判断响应是否说明上游本身出了问题：5xx 且不是 JSON-RPC 响应，例如网关返回的 HTML 502。
+// CometBFT 对参数错误或已裁剪的高度也返回 500，但响应体是 JSON-RPC 错误
+func (resp *upstreamResponse) failed() bool {
+	return resp.Status >= http.StatusInternalServerError && !isRPCResponse(resp.Body)
+}
+
+// forward 依次尝试满足高度要求的健康上游，直到成功或达到重试上限。非 200 视为失败，
+// 若已是最后一次尝试，则返回上游的 JSON-RPC 错误响应；所有上游都无响应或
+// 最后一次也是非 JSON-RPC 的 5xx 时返回错误，由调用方返回 JSON-RPC 错误
+func (h *proxyHandler) forward(r *http.Request, body []byte, methods string, rt route) (*upstreamResponse, error) {
+	var tried []*Upstream
+	var lastErr error
+	if entry := accessEntryFrom(r.Context()); entry != nil {
+		defer func() { entry.Attempts = len(tried) }()
+	}
+	for attempt := 0; attempt <= h.opts.Retries; attempt++ {
+		upstream := h.pool.Pick(tried, rt)
+		if upstream == nil {
+			break
+		}
+		tried = append(tried, upstream)
+		last := attempt == h.opts.Retries || len(tried) == h.pool.eligible(rt)
+
+		resp, err := h.attempt(r, upstream, body, methods, attempt+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Status != http.StatusOK && (!last || resp.failed()) {
+			lastErr = fmt.Errorf("non-200 response from %s: %d", upstream.URL, resp.Status)
+			continue
+		}
+		return resp, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no upstream available")
+	}
+	return nil, fmt.Errorf("%d attempts failed, last error: %v", len(tried), lastErr)
+}
+
+// attempt 向一个上游发送请求并上报结果，返回任意状态码的响应；
+// 请求被取消（客户端断开或对冲中输掉）时不计为上游的失败
+func (h *proxyHandler) attempt(r *http.Request, upstream *Upstream, body []byte, methods string, n int) (*upstreamResponse, error) {
+	logger := requestLogger(r.Context())
+	start := time.Now()
+	resp, err := h.do(upstream, r, body)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return nil, err
+		}
+		logger.Warn("Upstream attempt failed", "attempt", n, "upstream", upstream.URL, "methods", methods, "error", err)
+		if !errors.Is(err, errResponseTooLarge) {
+			h.pool.ReportFailure(upstream)
+		}
+		return nil, err
+	}
+
+	// 只有非 JSON-RPC 的 5xx 计为上游失败；其他非 200 响应（例如参数错误或高度已裁剪时的
+	// JSON-RPC 错误）说明上游在正常工作，按成功上报给熔断器，但不计入延迟
+	switch {
+	case resp.failed():
+		logger.Warn("Non-200 response from upstream", "upstream", upstream.URL, "status", resp.Status)
+		h.pool.ReportFailure(upstream)
+	case resp.Status != http.StatusOK:
+		logger.Debug("Non-200 response from upstream", "upstream", upstream.URL, "status", resp.Status)
+		h.pool.ReportSuccess(upstream, 0)
+	default:
+		h.pool.ReportSuccess(upstream, time.Since(start))
+		h.latencies.Observe(time.Since(start))
+		if methods == "status" {
+			learnHeights(upstream, resp.Body)
+		}
+	}
+
+	// 记录目标服务器的响应信息
+	logger.Debug("Response from target", "upstream", upstream.URL, "methods", methods, "status", resp.Status, "duration", time.Since(start))
+	return resp, nil
+}
+
+// do 将缓存的请求发往指定上游并读取完整的响应
+func (h *proxyHandler) do(upstream *Upstream, r *http.Request, body []byte) (*upstreamResponse, error) {
+	ctx := r.Context()
+	if h.opts.UpstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.opts.UpstreamTimeout)
+		defer cancel()
+	}
+	req, err := newUpstreamRequest(ctx, upstream.URL, r, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %v", err)
+	}
+	upstream.outstanding.Add(1)
+	defer upstream.outstanding.Add(-1)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxResponseBodyBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %v", err)
+	}
+	if len(respBody) > maxResponseBodyBytes {
+		return nil, errResponseTooLarge
+	}
+	return &upstreamResponse{Upstream: upstream, Status: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+
+// logStats 定期输出缓存命中率和请求合并率
+func (h *proxyHandler) logStats(ctx context.Context, interval time.Duration) {
+	var lastHits, lastMisses, lastRequests int64
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if h.cache != nil {
+			hits, misses, entries, bytes := h.cache.Stats()
+			if hits != lastHits || misses != lastMisses {
+				lastHits, lastMisses = hits, misses
+				log.Printf("Cache stats: hits: %d, misses: %d, hit ratio: %.2f, entries: %d, bytes: %d",
+					hits, misses, float64(hits)/float64(hits+misses), entries, bytes)
+			}
+		}
+		if h.coalescer != nil {
+			requests, coalesced := h.coalescer.Stats()
+			if requests != lastRequests {
+				lastRequests = requests
+				log.Printf("Coalescing stats: requests: %d, coalesced: %d, ratio: %.2f",
+					requests, coalesced, float64(coalesced)/float64(requests))
+			}
+		}
+	}
+}
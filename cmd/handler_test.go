@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testUpstream 是记录收到的请求的假上游节点，默认对每个请求返回空结果
+type testUpstream struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string // "方法 路径?查询 请求体"
+	handler  http.HandlerFunc
+}
+
+func newTestUpstream(t *testing.T, handler http.HandlerFunc) *testUpstream {
+	t.Helper()
+	u := &testUpstream{handler: handler}
+	if u.handler == nil {
+		u.handler = func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{}}`)
+		}
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		u.mu.Lock()
+		u.requests = append(u.requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		u.mu.Unlock()
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		u.handler(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// received 返回上游收到的请求
+func (u *testUpstream) received() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.requests...)
+}
+
+// newTestHandler 创建转发到 upstreams 的代理处理器，configure 可以修改默认配置
+func newTestHandler(t *testing.T, upstreams []*testUpstream, configure func(*proxyOptions)) *proxyHandler {
+	t.Helper()
+	var urls []string
+	for _, u := range upstreams {
+		urls = append(urls, u.URL)
+	}
+	opts := proxyOptions{
+		Costs:           defaultCostTable(),
+		Policy:          &Policy{DenyMethods: defaultDeniedMethods},
+		HedgePercentile: 0.95,
+		MaxReadLag:      -1,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	h := newProxyHandler(context.Background(), NewUpstreamPool(urls), opts)
+	t.Cleanup(h.stop)
+	return h
+}
+
+// serve 向代理发送一个请求并返回响应
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// rpcError 返回响应体中第一个 JSON-RPC 错误，没有错误时返回 nil
+func rpcError(t *testing.T, body []byte) *RPCError {
+	t.Helper()
+	var resps []RPCResponse
+	if err := json.Unmarshal(body, &resps); err != nil {
+		var resp RPCResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("invalid JSON-RPC response %s: %v", body, err)
+		}
+		resps = []RPCResponse{resp}
+	}
+	for _, resp := range resps {
+		if resp.Error != nil {
+			return resp.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tedtool/cmd/peer"
+)
+
+// HealthCheck 是主动健康检查的配置
+type HealthCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// MaxLag 是允许落后池内最高高度的区块数
+	MaxLag int64
+	// MaxBlockAge 是最新区块时间距今的上限，超过说明节点停止出块或同步
+	MaxBlockAge time.Duration
+}
+
+// fetchStatus 请求上游的 /status
+func fetchStatus(ctx context.Context, client *http.Client, targetURL string) (*peer.StatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(targetURL, "/")+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxResponseBodyBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseBodyBytes {
+		return nil, errResponseTooLarge
+	}
+	var status peer.StatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("invalid /status response: %v", err)
+	}
+	return &status, nil
+}
+
+// HealthCheck 定期并发检查所有上游的 /status，ctx 结束时退出。
+// 请求失败、仍在同步、最新区块过旧或落后池内最高高度超过 MaxLag 的上游被标记为不健康，
+// 当前上游不健康时立即切换
+func (p *UpstreamPool) HealthCheck(ctx context.Context, cfg HealthCheck) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		p.checkOnce(ctx, client, cfg)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkOnce 执行一轮健康检查
+func (p *UpstreamPool) checkOnce(ctx context.Context, client *http.Client, cfg HealthCheck) {
+	upstreams := p.Upstreams()
+	statuses := make([]*peer.StatusResponse, len(upstreams))
+	errs := make([]error, len(upstreams))
+
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		go func(i int, u *Upstream) {
+			defer wg.Done()
+			start := time.Now()
+			statuses[i], errs[i] = fetchStatus(ctx, client, u.URL)
+			// 健康检查的延迟让还没有承接请求的上游也有延迟数据
+			if errs[i] == nil {
+				u.ObserveLatency(time.Since(start))
+			}
+		}(i, u)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	// 先记录各上游的高度，得到池内的最高高度
+	heights := make([]int64, len(upstreams))
+	var best int64
+	for i, u := range upstreams {
+		if errs[i] != nil {
+			continue
+		}
+		height, err := recordSyncInfo(u, statuses[i].Result.SyncInfo)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		heights[i] = height
+		if height > best {
+			best = height
+		}
+	}
+
+	for i, u := range upstreams {
+		var problem error
+		switch {
+		case errs[i] != nil:
+			problem = errs[i]
+		case statuses[i].Result.SyncInfo.CatchingUp:
+			problem = fmt.Errorf("catching up at height %d", heights[i])
+		case cfg.MaxBlockAge > 0 && time.Since(statuses[i].Result.SyncInfo.LatestBlockTime) > cfg.MaxBlockAge:
+			problem = fmt.Errorf("latest block is %v old", time.Since(statuses[i].Result.SyncInfo.LatestBlockTime).Round(time.Second))
+		case cfg.MaxLag > 0 && best-heights[i] > cfg.MaxLag:
+			problem = fmt.Errorf("%d blocks behind best height %d", best-heights[i], best)
+		}
+		p.setCheckResult(u, problem)
+	}
+}
+
+// recordSyncInfo 根据 /status 的 sync_info 更新上游的最新和最早高度，返回最新高度
+func recordSyncInfo(u *Upstream, info peer.SyncInfo) (int64, error) {
+	height, err := strconv.ParseInt(info.LatestBlockHeight, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid latest_block_height %q", info.LatestBlockHeight)
+	}
+	earliest, _ := strconv.ParseInt(info.EarliestBlockHeight, 10, 64)
+	u.SetHeights(height, earliest)
+	return height, nil
+}
+
+// RefreshHeights 并发请求最新高度低于 below 的上游的 /status，只更新高度，不影响健康状态
+func (p *UpstreamPool) RefreshHeights(ctx context.Context, client *http.Client, below int64) {
+	var wg sync.WaitGroup
+	for _, u := range p.Upstreams() {
+		if latest, _ := u.Height(); latest >= below {
+			continue
+		}
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			if status, err := fetchStatus(ctx, client, u.URL); err == nil {
+				recordSyncInfo(u, status.Result.SyncInfo)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// setCheckResult 记录一次健康检查的结果，状态变化时输出日志，
+// 当前上游不健康且有其他健康的上游时切换
+func (p *UpstreamPool) setCheckResult(u *Upstream, problem error) {
+	u.mu.Lock()
+	wasFailing := u.checkErr != nil
+	u.checkErr = problem
+	healthy := u.healthyLocked()
+	u.mu.Unlock()
+
+	switch {
+	case problem != nil && !wasFailing:
+		log.Printf("Health check failed for %s: %v", u.URL, problem)
+	case problem == nil && wasFailing:
+		log.Printf("Health check passed for %s again", u.URL)
+	}
+	metricUpstreamHealthy.WithLabelValues(u.URL).Set(boolToFloat(healthy))
+
+	if problem != nil && p.Current() == u && p.anyHealthy() {
+		p.SwitchFrom(u)
+	}
+}
+
+// anyHealthy 判断池中是否还有健康的上游
+func (p *UpstreamPool) anyHealthy() bool {
+	for _, u := range p.Upstreams() {
+		if u.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// statusUpstream 返回一个 /status 报告给定高度、区块时间和同步状态的上游
+func statusUpstream(t *testing.T, height int64, blockTime time.Time, catchingUp bool) *testUpstream {
+	return newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"sync_info":{"latest_block_height":"%d","earliest_block_height":"5","latest_block_time":%q,"catching_up":%t}}}`,
+			height, blockTime.Format(time.RFC3339Nano), catchingUp)
+	})
+}
+
+// TestCheckOnce 检查一轮健康检查标记出各种不健康的上游，并从不健康的当前上游切换走
+func TestCheckOnce(t *testing.T) {
+	now := time.Now()
+	upstreams := []*testUpstream{
+		statusUpstream(t, 100, now, true),                  // 仍在同步
+		statusUpstream(t, 100, now.Add(-time.Hour), false), // 最新区块过旧
+		statusUpstream(t, 90, now, false),                  // 落后超过 MaxLag
+		newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}),
+		newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{"sync_info":{"latest_block_height":"abc"}}}`)
+		}),
+		statusUpstream(t, 98, now, false), // 落后但在 MaxLag 之内
+	}
+	urls := make([]string, len(upstreams))
+	for i, u := range upstreams {
+		urls[i] = u.URL
+	}
+	pool := NewUpstreamPool(urls)
+	cfg := HealthCheck{MaxLag: 5, MaxBlockAge: time.Minute}
+	pool.checkOnce(context.Background(), http.DefaultClient, cfg)
+
+	for i, u := range pool.Upstreams() {
+		if want := i == len(upstreams)-1; u.Healthy() != want {
+			t.Errorf("upstream %d healthy = %v, want %v", i, u.Healthy(), want)
+		}
+	}
+	last := pool.Upstreams()[len(upstreams)-1]
+	if pool.Current() != last {
+		t.Errorf("current upstream = %s, want the healthy %s", pool.Current().URL, last.URL)
+	}
+	if latest, _ := last.Height(); latest != 98 || last.EarliestHeight() != 5 {
+		t.Errorf("heights = %d, %d, want 98, 5", latest, last.EarliestHeight())
+	}
+
+	// 关闭落后检查后，落后的上游在下一轮检查通过并重新变为健康
+	cfg.MaxLag = 0
+	pool.checkOnce(context.Background(), http.DefaultClient, cfg)
+	if !pool.Upstreams()[2].Healthy() {
+		t.Error("lagging upstream still unhealthy with the lag check disabled")
+	}
+}
+
+// TestRefreshHeights 检查只刷新低于给定高度的上游，且不改变健康状态
+func TestRefreshHeights(t *testing.T) {
+	behind := statusUpstream(t, 100, time.Now(), true)
+	ahead := statusUpstream(t, 200, time.Now(), false)
+	pool := NewUpstreamPool([]string{behind.URL, ahead.URL})
+	pool.Upstreams()[1].SetHeights(150, 0)
+
+	pool.RefreshHeights(context.Background(), http.DefaultClient, 120)
+	if latest, _ := pool.Upstreams()[0].Height(); latest != 100 {
+		t.Errorf("behind upstream height = %d, want 100", latest)
+	}
+	if latest, _ := pool.Upstreams()[1].Height(); latest != 150 {
+		t.Errorf("upstream already above the target refreshed to %d", latest)
+	}
+	if !pool.Upstreams()[0].Healthy() {
+		t.Error("refreshing heights changed the health state")
+	}
+	if n := len(ahead.received()); n != 0 {
+		t.Errorf("upstream already above the target received %d requests", n)
+	}
+}
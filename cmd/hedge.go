@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples 是用于计算对冲延迟的最近延迟样本数
+	latencySamples = 512
+	// minLatencySamples 是按百分位计算对冲延迟所需的最少样本数
+	minLatencySamples = 20
+)
+
+// latencyWindow 保存最近的上游延迟，用于计算百分位
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+// Observe 记录一次延迟，样本满后覆盖最早的样本
+func (w *latencyWindow) Observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+// Percentile 返回最近延迟的 p 分位数，样本不足时返回 false
+func (w *latencyWindow) Percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	if len(w.samples) < minLatencySamples {
+		w.mu.Unlock()
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))], true
+}
+
+// hedgeable 判断请求能否对冲：需要开启对冲，且所有调用都是只读的，交易广播永远不会被对冲
+func (h *proxyHandler) hedgeable(rpc *RPCRequest) bool {
+	if !h.opts.Hedge || len(rpc.Calls) == 0 {
+		return false
+	}
+	for _, call := range rpc.Calls {
+		if !isReadOnly(call.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+// hedgeDelay 返回发出对冲请求前的等待时间
+func (h *proxyHandler) hedgeDelay() time.Duration {
+	d, ok := h.latencies.Percentile(h.opts.HedgePercentile)
+	if !ok || d < h.opts.HedgeMinDelay {
+		return h.opts.HedgeMinDelay
+	}
+	return d
+}
+
+type attemptResult struct {
+	upstream *Upstream
+	resp     *upstreamResponse
+	err      error
+}
+
+// forwardHedged 与 forward 一样依次尝试上游，但第一个上游在对冲延迟内没有响应时，
+// 不等它结束就向第二个上游发出同样的请求。先成功的响应胜出，其余请求被取消
+func (h *proxyHandler) forwardHedged(r *http.Request, body []byte, methods string, rt route) (*upstreamResponse, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	hr := r.WithContext(ctx)
+	logger := requestLogger(r.Context())
+
+	var tried []*Upstream
+	if entry := accessEntryFrom(r.Context()); entry != nil {
+		defer func() { entry.Attempts = len(tried) }()
+	}
+	results := make(chan attemptResult, h.opts.Retries+1)
+	inflight := 0
+	launch := func() bool {
+		if len(tried) > h.opts.Retries {
+			return false
+		}
+		upstream := h.pool.Pick(tried, rt)
+		if upstream == nil {
+			return false
+		}
+		tried = append(tried, upstream)
+		inflight++
+		n := len(tried)
+		go func() {
+			resp, err := h.attempt(hr, upstream, body, methods, n)
+			results <- attemptResult{upstream: upstream, resp: resp, err: err}
+		}()
+		return true
+	}
+
+	if !launch() {
+		return nil, fmt.Errorf("0 attempts failed, last error: no upstream available")
+	}
+	delay := h.hedgeDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	hedged := false
+	var lastResp *upstreamResponse
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case <-timer.C:
+			if !hedged && launch() {
+				hedged = true
+				metricHedges.WithLabelValues("fired").Inc()
+				logger.Debug("Hedging request", "upstream", tried[len(tried)-1].URL, "methods", methods, "delay", delay)
+			}
+		case res := <-results:
+			inflight--
+			if res.err == nil && res.resp.Status == http.StatusOK {
+				if hedged && res.upstream != tried[0] {
+					metricHedges.WithLabelValues("won").Inc()
+				}
+				return res.resp, nil
+			}
+			if res.err != nil {
+				lastErr = res.err
+			} else {
+				if !res.resp.failed() {
+					lastResp = res.resp
+				}
+				lastErr = fmt.Errorf("non-200 response from %s: %d", res.upstream.URL, res.resp.Status)
+			}
+			// 没有其他请求在进行时立即重试下一个上游
+			if inflight == 0 {
+				launch()
+			}
+		}
+	}
+
+	// 与 forward 一样，所有尝试都失败时返回最后一个上游的 JSON-RPC 错误响应
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, fmt.Errorf("%d attempts failed, last error: %v", len(tried), lastErr)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLatencyWindow 检查样本不足时不给出百分位，样本满后覆盖最早的样本
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow(100)
+	for i := 1; i < minLatencySamples; i++ {
+		w.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := w.Percentile(0.5); ok {
+		t.Errorf("percentile available with %d samples", minLatencySamples-1)
+	}
+
+	for i := minLatencySamples; i <= 100; i++ {
+		w.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if d, _ := w.Percentile(0.95); d != 95*time.Millisecond {
+		t.Errorf("p95 = %v, want 95ms", d)
+	}
+	for i := 0; i < 100; i++ {
+		w.Observe(time.Second)
+	}
+	if d, _ := w.Percentile(0.05); d != time.Second {
+		t.Errorf("p5 after overwriting every sample = %v, want 1s", d)
+	}
+}
+
+// TestHedgeDelay 检查对冲延迟不低于最小延迟
+func TestHedgeDelay(t *testing.T) {
+	h := newTestHandler(t, nil, func(opts *proxyOptions) {
+		opts.HedgeMinDelay = 50 * time.Millisecond
+		opts.HedgePercentile = 0.9
+	})
+	if d := h.hedgeDelay(); d != 50*time.Millisecond {
+		t.Errorf("delay without samples = %v, want the minimum", d)
+	}
+	for i := 0; i < minLatencySamples; i++ {
+		h.latencies.Observe(10 * time.Millisecond)
+	}
+	if d := h.hedgeDelay(); d != 50*time.Millisecond {
+		t.Errorf("delay below the minimum = %v, want the minimum", d)
+	}
+	for i := 0; i < minLatencySamples*2; i++ {
+		h.latencies.Observe(200 * time.Millisecond)
+	}
+	if d := h.hedgeDelay(); d != 200*time.Millisecond {
+		t.Errorf("delay = %v, want the p90 latency", d)
+	}
+}
+
+// slowUpstream 返回一个等到请求被取消或超时才响应的上游，请求被取消时向 canceled 发送信号
+func slowUpstream(t *testing.T, canceled chan<- struct{}) *testUpstream {
+	return newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(2 * time.Second):
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{"from":"slow"}}`)
+		}
+	})
+}
+
+// TestHedgedRequest 检查慢的只读请求被对冲到第二个上游，先到的响应胜出并取消另一个请求
+func TestHedgedRequest(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	slow := slowUpstream(t, canceled)
+	fast := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":-1,"result":{"from":"fast"}}`)
+	})
+	h := newTestHandler(t, []*testUpstream{slow, fast}, func(opts *proxyOptions) {
+		opts.Hedge = true
+		opts.HedgeMinDelay = 20 * time.Millisecond
+		opts.Retries = 1
+	})
+
+	start := time.Now()
+	w := serve(h, http.MethodGet, "/status", "")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took %v", elapsed)
+	}
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"from":"fast"`) {
+		t.Fatalf("response = %d %s, want the fast upstream's result", w.Code, w.Body)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("losing request was not canceled")
+	}
+}
+
+// TestBroadcastNotHedged 检查交易广播即使很慢也不会被发送到第二个上游
+func TestBroadcastNotHedged(t *testing.T) {
+	first := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"code":0}}`)
+	})
+	second := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{first, second}, func(opts *proxyOptions) {
+		opts.Hedge = true
+		opts.HedgeMinDelay = 10 * time.Millisecond
+		opts.Retries = 1
+	})
+
+	w := serve(h, http.MethodPost, "/", `{"jsonrpc":"2.0","id":1,"method":"broadcast_tx_sync","params":{"tx":"AQ=="}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if n := len(second.received()); n != 0 {
+		t.Errorf("broadcast was hedged, second upstream received %d requests", n)
+	}
+}
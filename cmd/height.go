@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// fullHistory 表示调用需要的高度未知但依赖历史数据，只发往保留区块最早的上游
+const fullHistory int64 = -1
+
+// heightConditionPattern 匹配 tx_search 和 block_search 查询中的高度条件
+var heightConditionPattern = regexp.MustCompile(`(?:tx|block)\.height\s*(>=|<=|=|>|<)\s*(\d+)`)
+
+// requiredHeight 返回调用需要上游保留的最低区块高度，0 表示任何上游都可以
+func requiredHeight(call RPCCall) int64 {
+	switch call.Method {
+	case "tx", "block_by_hash", "header_by_hash":
+		// 按哈希查询无法得知高度
+		return fullHistory
+	case "tx_search", "block_search":
+		query, _ := call.Param("query")
+		return queryMinHeight(query)
+	case "blockchain":
+		v, _ := call.Param("minHeight")
+		if h, err := strconv.ParseInt(v, 10, 64); err == nil && h > 0 {
+			return h
+		}
+		return 0
+	}
+	return pinnedHeight(call)
+}
+
+// queryMinHeight 从查询的高度条件中取出下限，条件之间是 AND 关系，取最大的下限；
+// 没有下限时需要完整的历史
+func queryMinHeight(query string) int64 {
+	var min int64
+	for _, m := range heightConditionPattern.FindAllStringSubmatch(query, -1) {
+		h, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch m[1] {
+		case ">":
+			h++
+		case "<", "<=":
+			continue
+		}
+		if h > min {
+			min = h
+		}
+	}
+	if min == 0 {
+		return fullHistory
+	}
+	return min
+}
+
+// requestRequiredHeight 返回请求中所有调用需要的最低高度
+func requestRequiredHeight(rpc *RPCRequest) int64 {
+	var need int64
+	for _, call := range rpc.Calls {
+		h := requiredHeight(call)
+		switch {
+		case h == fullHistory:
+			return fullHistory
+		case h > 0 && (need == 0 || h < need):
+			need = h
+		}
+	}
+	return need
+}
+
+// readsLatestState 判断请求是否包含读取最新状态的调用：status、abci_info，
+// 以及不带高度的 block、commit、validators 等。只有这些调用需要避免先后两次看到的高度倒退
+func readsLatestState(rpc *RPCRequest) bool {
+	for _, call := range rpc.Calls {
+		switch {
+		case call.Method == "status" || call.Method == "abci_info":
+			return true
+		case heightPinnedMethods[call.Method] && pinnedHeight(call) == 0:
+			return true
+		}
+	}
+	return false
+}
+
+// route 是请求对上游区块高度的要求
+type route struct {
+	// Need 是上游需要保留的最低高度，见 requiredHeight
+	Need int64
+	// MinLatest 是最新高度的下限，来自客户端的要求或已经返回过的最高高度，达不到的上游不会被选中
+	MinLatest int64
+}
+
+// canServe 判断上游是否满足请求的高度要求，archive 是池内已知的最早高度；
+// 上游的最早高度未知时视为保留了所有区块
+func canServe(u *Upstream, rt route, archive int64) bool {
+	if rt.MinLatest > 0 {
+		if latest, _ := u.Height(); latest < rt.MinLatest {
+			return false
+		}
+	}
+	earliest := u.EarliestHeight()
+	switch {
+	case rt.Need == 0 || earliest == 0:
+		return true
+	case rt.Need == fullHistory:
+		return earliest <= archive
+	}
+	return earliest <= rt.Need
+}
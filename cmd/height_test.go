@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequiredHeight(t *testing.T) {
+	tests := []struct {
+		method string
+		params string
+		want   int64
+	}{
+		{"status", `{}`, 0},
+		{"block", `{}`, 0},
+		{"block", `{"height":"42"}`, 42},
+		{"block_results", `{"height":42}`, 42},
+		{"validators", `{"height":"0"}`, 0},
+		{"tx", `{"hash":"0xAB"}`, fullHistory},
+		{"block_by_hash", `{"hash":"0xAB"}`, fullHistory},
+		{"tx_search", `{"query":"transfer.sender='a'"}`, fullHistory},
+		{"tx_search", `{"query":"tx.height>=10 AND tx.height<20"}`, 10},
+		{"tx_search", `{"query":"tx.height > 10"}`, 11},
+		{"block_search", `{"query":"block.height=7 AND block.height>=3"}`, 7},
+		{"tx_search", `{"query":"tx.height<=100"}`, fullHistory},
+		{"blockchain", `{"minHeight":"7","maxHeight":"9"}`, 7},
+		{"blockchain", `{}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.params, func(t *testing.T) {
+			call := RPCCall{Method: tt.method, Params: json.RawMessage(tt.params)}
+			if got := requiredHeight(call); got != tt.want {
+				t.Errorf("requiredHeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanServe(t *testing.T) {
+	tests := []struct {
+		name     string
+		latest   int64
+		earliest int64
+		rt       route
+		archive  int64
+		want     bool
+	}{
+		{"no requirement", 100, 50, route{}, 1, true},
+		{"height retained", 100, 50, route{Need: 60}, 1, true},
+		{"height pruned", 100, 50, route{Need: 40}, 1, false},
+		{"earliest unknown", 100, 0, route{Need: 40}, 1, true},
+		{"full history on archive", 100, 1, route{Need: fullHistory}, 1, true},
+		{"full history on pruned node", 100, 50, route{Need: fullHistory}, 1, false},
+		{"min latest reached", 100, 1, route{MinLatest: 100}, 1, true},
+		{"min latest not reached", 99, 1, route{MinLatest: 100}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Upstream{URL: "test"}
+			u.SetHeights(tt.latest, tt.earliest)
+			if got := canServe(u, tt.rt, tt.archive); got != tt.want {
+				t.Errorf("canServe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// JSON-RPC 错误码，-32000 ~ -32099 为服务端自定义错误
+const (
+	codeParseError         = -32700
+	codeInvalidRequest     = -32600
+	codeInvalidParams      = -32602
+	codeInternalError      = -32603
+	codeUpstreamsFailed    = -32001
+	codeRateLimited        = -32002
+	codeMethodNotAllowed   = -32003
+	codeInvalidAPIKey      = -32004
+	codeQuotaExceeded      = -32005
+	codeHeightUnavailable  = -32006
+	codeHeightNotReached   = -32007
+	codeQuorumFailed       = -32008
+	codeVerificationFailed = -32009
+	codeRequestTooLarge    = -32010
+)
+
+// RPCError 是 JSON-RPC 2.0 的错误对象
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+// RPCResponse 是 JSON-RPC 2.0 的响应对象
+type RPCResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCCall 是一次 JSON-RPC 调用
+type RPCCall struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Param 返回命名参数的值，字符串参数会去掉引号；不存在时返回 false
+func (c RPCCall) Param(name string) (string, bool) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(c.Params, &params); err != nil {
+		return "", false
+	}
+	raw, ok := params[name]
+	if !ok || string(raw) == "null" {
+		return "", false
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, true
+	}
+	return string(raw), true
+}
+
+// NamedParams 返回调用的所有命名参数，字符串参数会去掉引号；参数是按位置传递的数组时返回 false
+func (c RPCCall) NamedParams() (map[string]string, bool) {
+	params := make(map[string]string)
+	trimmed := bytes.TrimSpace(c.Params)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return params, true
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &raw); err != nil {
+		return nil, false
+	}
+	for name := range raw {
+		if v, ok := c.Param(name); ok {
+			params[name] = v
+		}
+	}
+	return params, true
+}
+
+// RPCRequest 是解析后的客户端请求，可能是单个调用、批量调用或 URI 形式的 GET 调用
+type RPCRequest struct {
+	Calls []RPCCall
+	Batch bool
+}
+
+// Methods 返回请求中的所有方法名
+func (req *RPCRequest) Methods() []string {
+	methods := make([]string, 0, len(req.Calls))
+	for _, c := range req.Calls {
+		methods = append(methods, c.Method)
+	}
+	return methods
+}
+
+// errMethodPathVerb 表示 /<method> 路径上的请求使用了可能带表单参数的 HTTP 方法
+var errMethodPathVerb = errors.New("only GET, HEAD and OPTIONS are allowed on /<method> paths, POST JSON-RPC calls to /")
+
+// parseRPCRequest 解析上游实际会执行的调用。CometBFT 在 /<method> 上注册的 URI 处理器
+// 不区分 HTTP 方法，所以非根路径上的 GET、HEAD 和 OPTIONS 都按 URI 形式解析为对该方法的调用；
+// 上游还会从 POST、PUT 和 PATCH 的表单请求体中读取参数，所以其他 HTTP 方法直接拒绝。
+// 根路径上的请求体按 JSON-RPC 单个或批量调用解析，请求体为空时上游只返回路由列表，调用列表为空
+func parseRPCRequest(r *http.Request, body []byte) (*RPCRequest, error) {
+	if method := strings.TrimPrefix(r.URL.Path, "/"); method != "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return nil, errMethodPathVerb
+		}
+		params := make(map[string]string)
+		for name, values := range r.URL.Query() {
+			// URI 形式的字符串参数带引号，例如 ?hash="0xABCD"
+			params[name] = strings.Trim(values[0], `"`)
+		}
+		raw, _ := json.Marshal(params)
+		return &RPCRequest{Calls: []RPCCall{{ID: json.RawMessage("-1"), Method: method, Params: raw}}}, nil
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return &RPCRequest{}, nil
+	}
+	if trimmed[0] == '[' {
+		var calls []RPCCall
+		if err := json.Unmarshal(trimmed, &calls); err != nil {
+			return nil, err
+		}
+		if len(calls) == 0 {
+			return nil, fmt.Errorf("empty batch")
+		}
+		return &RPCRequest{Calls: calls, Batch: true}, nil
+	}
+
+	var call RPCCall
+	if err := json.Unmarshal(trimmed, &call); err != nil {
+		return nil, err
+	}
+	return &RPCRequest{Calls: []RPCCall{call}}, nil
+}
+
+// isRPCResponse 判断 body 是否是 JSON-RPC 响应对象或响应数组
+func isRPCResponse(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var resps []RPCResponse
+		if err := json.Unmarshal(trimmed, &resps); err != nil || len(resps) == 0 {
+			return false
+		}
+		return resps[0].Jsonrpc == "2.0"
+	}
+	var resp RPCResponse
+	if err := json.Unmarshal(trimmed, &resp); err != nil {
+		return false
+	}
+	return resp.Jsonrpc == "2.0"
+}
+
+// decodeRPCResponses 按响应体的形状解析单个响应或响应数组。
+// CometBFT 对只有一个调用的批量请求返回单个对象，不能按请求是否为批量来解析
+func decodeRPCResponses(body []byte) ([]RPCResponse, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var resps []RPCResponse
+		if err := json.Unmarshal(trimmed, &resps); err != nil {
+			return nil, fmt.Errorf("invalid batch response: %v", err)
+		}
+		return resps, nil
+	}
+	var resp RPCResponse
+	if err := json.Unmarshal(trimmed, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return []RPCResponse{resp}, nil
+}
+
+// writeRPCError 向客户端返回一个 JSON-RPC 错误对象
+func writeRPCError(w http.ResponseWriter, status int, id json.RawMessage, code int, message, data string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	resp := RPCResponse{
+		Jsonrpc: "2.0",
+		ID:      id,
+		Error:   &RPCError{Code: code, Message: message, Data: data},
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error writing JSON-RPC error: %v", err)
+	}
+}
+
+// writeRPCErrors 为请求中的每个调用返回同一个错误，保留调用方的 id，批量请求返回数组
+func writeRPCErrors(w http.ResponseWriter, status int, req *RPCRequest, code int, message, data string) {
+	if req == nil || !req.Batch {
+		id := json.RawMessage("null")
+		if req != nil && len(req.Calls) > 0 && len(req.Calls[0].ID) > 0 {
+			id = req.Calls[0].ID
+		}
+		writeRPCError(w, status, id, code, message, data)
+		return
+	}
+
+	resps := make([]RPCResponse, 0, len(req.Calls))
+	for _, c := range req.Calls {
+		id := c.ID
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		resps = append(resps, RPCResponse{
+			Jsonrpc: "2.0",
+			ID:      id,
+			Error:   &RPCError{Code: code, Message: message, Data: data},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resps); err != nil {
+		log.Printf("Error writing JSON-RPC error: %v", err)
+	}
+}
+
+// writeRPCResult 向客户端返回一个成功的 JSON-RPC 响应
+func writeRPCResult(w http.ResponseWriter, id json.RawMessage, result json.RawMessage) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(RPCResponse{Jsonrpc: "2.0", ID: id, Result: result}); err != nil {
+		log.Printf("Error writing JSON-RPC response: %v", err)
+	}
+}
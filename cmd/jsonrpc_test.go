@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRPCRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		wantErr     bool
+		wantBatch   bool
+		wantMethods []string
+		wantParams  string
+	}{
+		{name: "uri call", method: http.MethodGet, target: "/block?height=5", wantMethods: []string{"block"}, wantParams: `{"height":"5"}`},
+		{name: "uri quoted string", method: http.MethodGet, target: `/tx?hash="0xAB"`, wantMethods: []string{"tx"}, wantParams: `{"hash":"0xAB"}`},
+		{name: "route list", method: http.MethodGet, target: "/"},
+		{name: "cors preflight", method: http.MethodOptions, target: "/"},
+		{name: "head", method: http.MethodHead, target: "/status", wantMethods: []string{"status"}},
+		{name: "options on method path", method: http.MethodOptions, target: "/dial_seeds?seeds=x", wantMethods: []string{"dial_seeds"}},
+		{name: "post to method path", method: http.MethodPost, target: "/block?height=5", body: `{"id":1,"method":"status"}`, wantErr: true},
+		{name: "empty post to method path", method: http.MethodPost, target: "/unsafe_flush_mempool", wantErr: true},
+		{name: "get with body on root", method: http.MethodGet, target: "/", body: `{"id":1,"method":"status"}`, wantMethods: []string{"status"}},
+		{name: "empty post", method: http.MethodPost, target: "/", body: "  "},
+		{name: "single call", method: http.MethodPost, target: "/", body: `{"jsonrpc":"2.0","id":1,"method":"status"}`, wantMethods: []string{"status"}},
+		{name: "batch", method: http.MethodPost, target: "/", body: ` [{"id":1,"method":"status"},{"id":2,"method":"health"}]`, wantBatch: true, wantMethods: []string{"status", "health"}},
+		{name: "empty batch", method: http.MethodPost, target: "/", body: `[]`, wantErr: true},
+		{name: "invalid json", method: http.MethodPost, target: "/", body: `{"id":1,`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rpc, err := parseRPCRequest(r, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRPCRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if rpc.Batch != tt.wantBatch {
+				t.Errorf("Batch = %v, want %v", rpc.Batch, tt.wantBatch)
+			}
+			if got := strings.Join(rpc.Methods(), ","); got != strings.Join(tt.wantMethods, ",") {
+				t.Errorf("methods = %q, want %q", got, tt.wantMethods)
+			}
+			if tt.wantParams != "" && string(rpc.Calls[0].Params) != tt.wantParams {
+				t.Errorf("params = %s, want %s", rpc.Calls[0].Params, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestWriteRPCErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *RPCRequest
+		wantIDs []string // 批量请求返回数组，否则返回单个对象
+		batch   bool
+	}{
+		{"no request", nil, []string{"null"}, false},
+		{"no calls", &RPCRequest{}, []string{"null"}, false},
+		{"single call", &RPCRequest{Calls: []RPCCall{{ID: json.RawMessage(`"a"`)}}}, []string{`"a"`}, false},
+		{"call without id", &RPCRequest{Calls: []RPCCall{{Method: "status"}}}, []string{"null"}, false},
+		{"batch", &RPCRequest{Batch: true, Calls: []RPCCall{{ID: json.RawMessage("1")}, {}}}, []string{"1", "null"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeRPCErrors(w, http.StatusTooManyRequests, tt.req, codeRateLimited, "Rate limit exceeded", "retry later")
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+
+			var resps []RPCResponse
+			if tt.batch {
+				if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil {
+					t.Fatalf("invalid batch response %s: %v", w.Body, err)
+				}
+			} else {
+				var resp RPCResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("invalid response %s: %v", w.Body, err)
+				}
+				resps = []RPCResponse{resp}
+			}
+			if len(resps) != len(tt.wantIDs) {
+				t.Fatalf("got %d responses, want %d", len(resps), len(tt.wantIDs))
+			}
+			for i, resp := range resps {
+				if string(resp.ID) != tt.wantIDs[i] {
+					t.Errorf("response %d id = %s, want %s", i, resp.ID, tt.wantIDs[i])
+				}
+				if resp.Jsonrpc != "2.0" || resp.Error == nil || resp.Error.Code != codeRateLimited || resp.Error.Data != "retry later" {
+					t.Errorf("response %d = %+v", i, resp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package keys
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// KeysCmd represents the keys command
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage API keys for the proxy",
+	Long: `Create, revoke and report on the API keys accepted by proxy and proxys.
+Keys are read from --file and usage is read from --usage-file, the same files
+passed to the proxies with --keys-file and --usage-file.`,
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new API key",
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		tier, _ := cmd.Flags().GetString("tier")
+
+		store := openStore(cmd)
+		key, err := store.Create(name, tier)
+		if err != nil {
+			log.Fatalf("Failed to create key: %v", err)
+		}
+		fmt.Println(key)
+	},
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke <key>",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := openStore(cmd)
+		if err := store.Revoke(args[0]); err != nil {
+			log.Fatalf("Failed to revoke key: %v", err)
+		}
+		fmt.Printf("Revoked %s\n", args[0])
+	},
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report API keys and their usage per method",
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		store := openStore(cmd)
+
+		// 按名称排序输出
+		all := store.Keys()
+		ids := make([]string, 0, len(all))
+		for id := range all {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return all[ids[i]].Name < all[ids[j]].Name })
+
+		for _, id := range ids {
+			k := all[id]
+			status := "active"
+			if k.Revoked {
+				status = "revoked"
+			}
+			fmt.Printf("%s  name: %s, tier: %s, created: %s, %s\n", id, k.Name, k.Tier, k.Created.Format(time.RFC3339), status)
+
+			for i := 0; i < days; i++ {
+				day := time.Now().UTC().AddDate(0, 0, -i).Format(dayFormat)
+				u := store.Usage(id, day)
+				if len(u.Methods) == 0 {
+					continue
+				}
+				fmt.Printf("  %s  cost: %g\n", day, u.Cost)
+				methods := make([]string, 0, len(u.Methods))
+				for m := range u.Methods {
+					methods = append(methods, m)
+				}
+				sort.Strings(methods)
+				for _, m := range methods {
+					fmt.Printf("    %-24s %d\n", m, u.Methods[m])
+				}
+			}
+		}
+	},
+}
+
+func openStore(cmd *cobra.Command) *Store {
+	file, _ := cmd.Flags().GetString("file")
+	usageFile, _ := cmd.Flags().GetString("usage-file")
+	store, err := Open(file, usageFile)
+	if err != nil {
+		log.Fatalf("Failed to open key store: %v", err)
+	}
+	return store
+}
+
+func init() {
+	// 定义命令行标志
+	KeysCmd.PersistentFlags().String("file", "keys.json", "file containing API keys and tiers")
+	KeysCmd.PersistentFlags().String("usage-file", "usage.json", "file containing API key usage")
+
+	createCmd.Flags().String("name", "", "name of the key owner (required)")
+	createCmd.Flags().String("tier", "free", "tier of the key")
+	createCmd.MarkFlagRequired("name")
+	reportCmd.Flags().Int("days", 7, "number of days of usage to report")
+
+	KeysCmd.AddCommand(createCmd, revokeCmd, reportCmd)
+}
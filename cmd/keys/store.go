@@ -0,0 +1,337 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// dayFormat 是用量按天统计时使用的日期格式（UTC）
+	dayFormat = "2006-01-02"
+	// usageRetentionDays 是保留用量的天数，更早的用量在写入用量文件时删除
+	usageRetentionDays = 90
+)
+
+// Tier 是一个 API key 等级的限额
+type Tier struct {
+	Rate         float64  `json:"rate"`                    // 每秒开销单位，0 表示不限制
+	Burst        float64  `json:"burst"`                   // 令牌桶容量
+	DailyQuota   float64  `json:"daily_quota"`             // 每日开销单位上限，0 表示不限制
+	AllowMethods []string `json:"allow_methods,omitempty"` // 允许的方法，空表示不额外限制
+}
+
+// Key 是一个发放给合作方的 API key
+type Key struct {
+	Name    string    `json:"name"`
+	Tier    string    `json:"tier"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+// DailyUsage 是一个 key 在一天内的用量
+type DailyUsage struct {
+	Cost    float64          `json:"cost"`
+	Methods map[string]int64 `json:"methods"`
+}
+
+// keyFile 是 key 文件的内容
+type keyFile struct {
+	Tiers map[string]Tier `json:"tiers"`
+	Keys  map[string]*Key `json:"keys"`
+}
+
+// defaultTiers 是新建 key 文件时写入的等级
+func defaultTiers() map[string]Tier {
+	return map[string]Tier{
+		"free":      {Rate: 5, Burst: 10, DailyQuota: 10000},
+		"standard":  {Rate: 20, Burst: 40, DailyQuota: 200000},
+		"unlimited": {},
+	}
+}
+
+// Store 保存 API key、等级和用量。key 文件由 tedtool keys 维护，
+// 代理只读取并在文件变化时重新加载；用量单独写入 usage 文件
+type Store struct {
+	keyPath   string
+	usagePath string
+
+	mu      sync.Mutex
+	keys    keyFile
+	modTime time.Time
+	usage   map[string]map[string]*DailyUsage // key -> 日期 -> 用量
+	dirty   bool
+}
+
+// Open 打开 key 文件和用量文件，文件不存在时使用默认等级和空用量
+func Open(keyPath, usagePath string) (*Store, error) {
+	s := &Store{
+		keyPath:   keyPath,
+		usagePath: usagePath,
+		keys:      keyFile{Tiers: defaultTiers(), Keys: make(map[string]*Key)},
+		usage:     make(map[string]map[string]*DailyUsage),
+	}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(usagePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.usage); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", usagePath, err)
+		}
+	}
+	return s, nil
+}
+
+// Reload 在 key 文件有变化时重新读取，返回是否重新加载过
+func (s *Store) Reload() (bool, error) {
+	info, err := os.Stat(s.keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info.ModTime().Equal(s.modTime) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(s.keyPath)
+	if err != nil {
+		return false, err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %v", s.keyPath, err)
+	}
+	if kf.Tiers == nil {
+		kf.Tiers = defaultTiers()
+	}
+	if kf.Keys == nil {
+		kf.Keys = make(map[string]*Key)
+	}
+	s.keys = kf
+	s.modTime = info.ModTime()
+	return true, nil
+}
+
+// Lookup 返回有效 key 的信息和等级，key 不存在、已吊销或等级不存在时返回 false
+func (s *Store) Lookup(key string) (Key, Tier, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys.Keys[key]
+	if !ok || k.Revoked {
+		return Key{}, Tier{}, false
+	}
+	tier, ok := s.keys.Tiers[k.Tier]
+	if !ok {
+		return Key{}, Tier{}, false
+	}
+	return *k, tier, true
+}
+
+// Create 生成一个新 key 并写入 key 文件
+func (s *Store) Create(name, tier string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys.Tiers[tier]; !ok {
+		return "", fmt.Errorf("unknown tier %q", tier)
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	key := "tk_" + hex.EncodeToString(buf)
+	s.keys.Keys[key] = &Key{Name: name, Tier: tier, Created: time.Now().UTC()}
+	return key, s.saveKeysLocked()
+}
+
+// Revoke 吊销一个 key 并写入 key 文件
+func (s *Store) Revoke(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys.Keys[key]
+	if !ok {
+		return fmt.Errorf("key not found")
+	}
+	k.Revoked = true
+	return s.saveKeysLocked()
+}
+
+// Keys 返回所有 key 的副本
+func (s *Store) Keys() map[string]Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]Key, len(s.keys.Keys))
+	for id, k := range s.keys.Keys {
+		out[id] = *k
+	}
+	return out
+}
+
+// Charge 在每日配额内记录一次请求的开销和方法，返回记录的日期。
+// 检查和记录在同一次加锁中完成，并发的请求不会一起超出配额；超出时不记录并返回 false，
+// quota 为 0 表示不限制
+func (s *Store) Charge(key string, methods []string, cost, quota float64) (string, bool) {
+	day := time.Now().UTC().Format(dayFormat)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days := s.usage[key]
+	if days == nil {
+		days = make(map[string]*DailyUsage)
+		s.usage[key] = days
+	}
+	u := days[day]
+	if u == nil {
+		u = &DailyUsage{Methods: make(map[string]int64)}
+		days[day] = u
+	}
+	if quota > 0 && u.Cost+cost > quota {
+		return day, false
+	}
+	u.Cost += cost
+	for _, m := range methods {
+		u.Methods[m]++
+	}
+	s.dirty = true
+	return day, true
+}
+
+// Refund 撤销 Charge 在 day 记录的开销和方法，用于记录后又被拒绝的请求
+func (s *Store) Refund(key, day string, methods []string, cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.usage[key][day]
+	if u == nil {
+		return
+	}
+	u.Cost -= cost
+	if u.Cost < 0 {
+		u.Cost = 0
+	}
+	for _, m := range methods {
+		if u.Methods[m]--; u.Methods[m] <= 0 {
+			delete(u.Methods, m)
+		}
+	}
+	s.dirty = true
+}
+
+// Usage 返回 key 在指定日期的用量副本
+func (s *Store) Usage(key, day string) DailyUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.usage[key][day]
+	if u == nil {
+		return DailyUsage{}
+	}
+	out := DailyUsage{Cost: u.Cost, Methods: make(map[string]int64, len(u.Methods))}
+	for m, n := range u.Methods {
+		out.Methods[m] = n
+	}
+	return out
+}
+
+// Flush 在用量有变化时删除过期的用量并写入用量文件
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	s.pruneLocked(time.Now().UTC().AddDate(0, 0, -usageRetentionDays).Format(dayFormat))
+	if err := writeJSON(s.usagePath, s.usage); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// pruneLocked 删除早于 oldest 的用量，以及没有剩余用量的 key
+func (s *Store) pruneLocked(oldest string) {
+	for key, days := range s.usage {
+		for day := range days {
+			// 日期格式按字符串比较即按时间先后
+			if day < oldest {
+				delete(days, day)
+			}
+		}
+		if len(days) == 0 {
+			delete(s.usage, key)
+		}
+	}
+}
+
+func (s *Store) saveKeysLocked() error {
+	if err := writeJSON(s.keyPath, s.keys); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.keyPath); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// writeJSON 先写临时文件再重命名，避免读到写了一半的文件
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Watch 定期写入用量文件，并在 key 文件变化时重新加载
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// 退出前写入最后的用量
+			if err := s.Flush(); err != nil {
+				log.Printf("Error writing usage file %s: %v", s.usagePath, err)
+			}
+			return
+		}
+		if err := s.Flush(); err != nil {
+			log.Printf("Error writing usage file %s: %v", s.usagePath, err)
+		}
+		if reloaded, err := s.Reload(); err != nil {
+			log.Printf("Error reloading key file %s: %v", s.keyPath, err)
+		} else if reloaded {
+			log.Printf("Reloaded key file %s", s.keyPath)
+		}
+	}
+}
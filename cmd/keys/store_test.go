@@ -0,0 +1,112 @@
+package keys
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := Open(filepath.Join(dir, "keys.json"), filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCreateLookupRevoke(t *testing.T) {
+	s := openTestStore(t)
+	if _, err := s.Create("partner", "gold"); err == nil {
+		t.Error("Create() accepted an unknown tier")
+	}
+	key, err := s.Create("partner", "free")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, tier, ok := s.Lookup(key)
+	if !ok || k.Name != "partner" || tier.DailyQuota != defaultTiers()["free"].DailyQuota {
+		t.Fatalf("Lookup() = %+v, %+v, %v", k, tier, ok)
+	}
+
+	// 其他进程打开同一个 key 文件能看到新 key，吊销后重新加载即失效
+	other, err := Open(s.keyPath, s.usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := other.Lookup(key); !ok {
+		t.Error("key not found in a store opened from the same file")
+	}
+	if err := s.Revoke(key); err != nil {
+		t.Fatal(err)
+	}
+	// 修改时间精度可能不足以区分两次写入
+	other.modTime = time.Time{}
+	if _, err := other.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := other.Lookup(key); ok {
+		t.Error("revoked key still valid after reload")
+	}
+}
+
+// TestChargeQuota 检查并发的请求不会一起超出每日配额
+func TestChargeQuota(t *testing.T) {
+	s := openTestStore(t)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := s.Charge("k", []string{"status"}, 1, 10); ok {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	today := time.Now().UTC().Format(dayFormat)
+	if u := s.Usage("k", today); admitted != 10 || u.Cost != 10 || u.Methods["status"] != 10 {
+		t.Errorf("admitted %d requests, usage %+v; want 10 within the quota", admitted, u)
+	}
+
+	day, _ := s.Charge("unlimited", []string{"block"}, 5, 0)
+	s.Refund("unlimited", day, []string{"block"}, 5)
+	if u := s.Usage("unlimited", day); u.Cost != 0 || len(u.Methods) != 0 {
+		t.Errorf("usage after refund = %+v, want empty", u)
+	}
+}
+
+// TestFlushPrunesUsage 检查写入用量文件时删除过期的用量
+func TestFlushPrunesUsage(t *testing.T) {
+	s := openTestStore(t)
+	old := time.Now().UTC().AddDate(0, 0, -usageRetentionDays-1).Format(dayFormat)
+	s.usage["gone"] = map[string]*DailyUsage{old: {Cost: 1}}
+	s.usage["kept"] = map[string]*DailyUsage{old: {Cost: 1}}
+	s.Charge("kept", []string{"status"}, 1, 0)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(s.usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usage map[string]map[string]*DailyUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := usage["gone"]; ok {
+		t.Error("key without recent usage kept")
+	}
+	if len(usage["kept"]) != 1 || usage["kept"][old] != nil {
+		t.Errorf("usage of kept key = %v, want only today", usage["kept"])
+	}
+}
@@ -0,0 +1,76 @@
+package lightclient
+
+import (
+	"fmt"
+
+	"github.com/cometbft/cometbft/crypto/merkle"
+	cmtcrypto "github.com/cometbft/cometbft/proto/tendermint/crypto"
+	ics23 "github.com/cosmos/ics23/go"
+)
+
+// Cosmos SDK 应用返回的 ics23 证明类型：iavl 和 smt 证明键在某个 store 中，
+// simple 证明 store 的根哈希在 multistore 中
+var ics23Specs = map[string]*ics23.ProofSpec{
+	"ics23:iavl":   ics23.IavlSpec,
+	"ics23:simple": ics23.TendermintSpec,
+	"ics23:smt":    ics23.SmtSpec,
+}
+
+// ProofRuntime 返回能验证 CometBFT 自带的 simple:v 证明和 Cosmos SDK 的 ics23 证明的 ProofRuntime
+func ProofRuntime() *merkle.ProofRuntime {
+	prt := merkle.DefaultProofRuntime()
+	for typ := range ics23Specs {
+		prt.RegisterOpDecoder(typ, decodeICS23Op)
+	}
+	return prt
+}
+
+// ics23Op 是一步 ics23 证明，Run 返回证明算出的根哈希
+type ics23Op struct {
+	typ   string
+	key   []byte
+	spec  *ics23.ProofSpec
+	proof *ics23.CommitmentProof
+}
+
+func decodeICS23Op(pop cmtcrypto.ProofOp) (merkle.ProofOperator, error) {
+	spec, ok := ics23Specs[pop.Type]
+	if !ok {
+		return nil, fmt.Errorf("unexpected proof type %q", pop.Type)
+	}
+	proof := &ics23.CommitmentProof{}
+	if err := proof.Unmarshal(pop.Data); err != nil {
+		return nil, fmt.Errorf("invalid %s proof: %v", pop.Type, err)
+	}
+	return ics23Op{typ: pop.Type, key: pop.Key, spec: spec, proof: proof}, nil
+}
+
+// Run 没有参数时验证键不存在，一个参数时验证键对应的值
+func (op ics23Op) Run(args [][]byte) ([][]byte, error) {
+	root, err := op.proof.Calculate()
+	if err != nil {
+		return nil, fmt.Errorf("calculating %s root: %v", op.typ, err)
+	}
+	switch len(args) {
+	case 0:
+		if !ics23.VerifyNonMembership(op.spec, root, op.proof, op.key) {
+			return nil, fmt.Errorf("%s proof does not prove absence of key %X", op.typ, op.key)
+		}
+	case 1:
+		if !ics23.VerifyMembership(op.spec, root, op.proof, op.key, args[0]) {
+			return nil, fmt.Errorf("%s proof does not prove the value of key %X", op.typ, op.key)
+		}
+	default:
+		return nil, fmt.Errorf("%s proof expects at most one value, got %d", op.typ, len(args))
+	}
+	return [][]byte{root}, nil
+}
+
+func (op ics23Op) GetKey() []byte {
+	return op.key
+}
+
+func (op ics23Op) ProofOp() cmtcrypto.ProofOp {
+	data, _ := op.proof.Marshal()
+	return cmtcrypto.ProofOp{Type: op.typ, Key: op.key, Data: data}
+}
@@ -0,0 +1,184 @@
+package lightclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	"github.com/cometbft/cometbft/light/provider"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	"github.com/cometbft/cometbft/types"
+)
+
+// validatorsPerPage 是获取验证者集合时每页的数量，CometBFT 允许的最大值为 100
+const validatorsPerPage = 100
+
+// maxResponseBytes 限制读入内存的响应大小，超过时按没有响应处理
+const maxResponseBytes = 128 << 20
+
+// rpcError 是上游返回的 JSON-RPC 错误
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Message, e.Code, e.Data)
+}
+
+type resultCommit struct {
+	SignedHeader types.SignedHeader `json:"signed_header"`
+	Canonical    bool               `json:"canonical"`
+}
+
+// resultBlockResults 是 block_results 的完整结果
+type resultBlockResults struct {
+	Height                int64                     `json:"height"`
+	TxsResults            []*abci.ExecTxResult      `json:"txs_results"`
+	FinalizeBlockEvents   []abci.Event              `json:"finalize_block_events"`
+	ValidatorUpdates      []abci.ValidatorUpdate    `json:"validator_updates"`
+	ConsensusParamUpdates *cmtproto.ConsensusParams `json:"consensus_param_updates"`
+	AppHash               []byte                    `json:"app_hash"`
+}
+
+type resultValidators struct {
+	BlockHeight int64              `json:"block_height"`
+	Validators  []*types.Validator `json:"validators"`
+	Count       int                `json:"count"`
+	Total       int                `json:"total"`
+}
+
+// httpProvider 通过上游的 URI 形式 RPC 获取签名区块头和验证者集合
+type httpProvider struct {
+	chainID string
+	url     string
+	client  *http.Client
+}
+
+// NewHTTPProvider 返回从 targetURL 获取轻区块的 provider
+func NewHTTPProvider(chainID string, client *http.Client, targetURL string) provider.Provider {
+	return &httpProvider{chainID: chainID, url: strings.TrimSuffix(targetURL, "/"), client: client}
+}
+
+func (p *httpProvider) ChainID() string {
+	return p.chainID
+}
+
+func (p *httpProvider) String() string {
+	return p.url
+}
+
+// LightBlock 获取 height 的签名区块头和完整的验证者集合，height 为 0 时获取最新的区块
+func (p *httpProvider) LightBlock(ctx context.Context, height int64) (*types.LightBlock, error) {
+	query := url.Values{}
+	if height > 0 {
+		query.Set("height", strconv.FormatInt(height, 10))
+	}
+	var commit resultCommit
+	if err := p.call(ctx, "commit", query, &commit); err != nil {
+		return nil, err
+	}
+	sh := commit.SignedHeader
+	if sh.Header == nil || sh.Commit == nil {
+		return nil, provider.ErrBadLightBlock{Reason: errors.New("missing header or commit")}
+	}
+	// light.Client 不检查返回的高度，与 CometBFT 的 http provider 一样在这里检查
+	if height > 0 && sh.Height != height {
+		return nil, provider.ErrBadLightBlock{Reason: fmt.Errorf("height %d responded doesn't match height %d requested", sh.Height, height)}
+	}
+
+	var vals []*types.Validator
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("height", strconv.FormatInt(sh.Height, 10))
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(validatorsPerPage))
+		var result resultValidators
+		if err := p.call(ctx, "validators", query, &result); err != nil {
+			return nil, err
+		}
+		vals = append(vals, result.Validators...)
+		if len(result.Validators) == 0 || len(vals) >= result.Total {
+			break
+		}
+	}
+	valSet, err := types.ValidatorSetFromExistingValidators(vals)
+	if err != nil {
+		return nil, provider.ErrBadLightBlock{Reason: err}
+	}
+
+	lb := &types.LightBlock{SignedHeader: &sh, ValidatorSet: valSet}
+	if err := lb.ValidateBasic(p.chainID); err != nil {
+		return nil, provider.ErrBadLightBlock{Reason: err}
+	}
+	return lb, nil
+}
+
+// ReportEvidence 暂不支持向上游提交证据，light.Client 只会记录返回的错误
+func (p *httpProvider) ReportEvidence(context.Context, types.Evidence) error {
+	return errors.New("reporting evidence is not supported")
+}
+
+// call 发送一次 URI 形式的 RPC 调用，并用 CometBFT 的 JSON 编码解析结果
+func (p *httpProvider) call(ctx context.Context, method string, query url.Values, result any) error {
+	target := p.url + "/" + method
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	// light.Client 用 == 比较 provider 的错误，不能包装。除 ErrNoResponse 等几个错误外，
+	// 其他错误都会被当作恶意的 provider 而移除，所以网络错误、非 200 状态和无法解析的响应
+	// 都按没有响应处理，只有能解析却无效的轻区块才返回 ErrBadLightBlock
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return provider.ErrNoResponse
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil || len(body) > maxResponseBytes {
+		return provider.ErrNoResponse
+	}
+
+	// 高度超出范围时 CometBFT 返回 500 和 JSON-RPC 错误，需要先解析错误
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return provider.ErrNoResponse
+	}
+	if envelope.Error != nil {
+		return classifyError(envelope.Error)
+	}
+	if resp.StatusCode != http.StatusOK || len(envelope.Result) == 0 {
+		return provider.ErrNoResponse
+	}
+	if err := cmtjson.Unmarshal(envelope.Result, result); err != nil {
+		return provider.ErrBadLightBlock{Reason: fmt.Errorf("invalid /%s result: %v", method, err)}
+	}
+	return nil
+}
+
+// classifyError 把上游的高度错误转换为 light.Client 能识别的 provider 错误，
+// 其他错误多是上游暂时无法处理，按没有响应处理
+func classifyError(e *rpcError) error {
+	switch {
+	case strings.Contains(e.Data, "must be less than or equal to the current blockchain height"):
+		return provider.ErrHeightTooHigh
+	case strings.Contains(e.Data, "is not available"):
+		return provider.ErrLightBlockNotFound
+	}
+	return provider.ErrNoResponse
+}
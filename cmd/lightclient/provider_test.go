@@ -0,0 +1,69 @@
+package lightclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cometbft/cometbft/light/provider"
+)
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"html gateway error", http.StatusBadGateway, "<html>502 Bad Gateway</html>", provider.ErrNoResponse},
+		{"status without error", http.StatusServiceUnavailable, `{"jsonrpc":"2.0","id":-1}`, provider.ErrNoResponse},
+		{"invalid json", http.StatusOK, "{", provider.ErrNoResponse},
+		{"empty result", http.StatusOK, `{"jsonrpc":"2.0","id":-1}`, provider.ErrNoResponse},
+		{"unknown rpc error", http.StatusInternalServerError,
+			`{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error","data":"timed out"}}`, provider.ErrNoResponse},
+		{"height too high", http.StatusInternalServerError,
+			`{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error","data":"height 20 must be less than or equal to the current blockchain height 10"}}`, provider.ErrHeightTooHigh},
+		{"pruned height", http.StatusInternalServerError,
+			`{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error","data":"height 1 is not available, lowest height is 5"}}`, provider.ErrLightBlockNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			p := NewHTTPProvider(testChainID, srv.Client(), srv.URL)
+			// light.Client 用 == 比较错误，这里也不能用 errors.Is
+			if _, err := p.LightBlock(context.Background(), 1); err != tt.want {
+				t.Fatalf("LightBlock() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	p := NewHTTPProvider(testChainID, http.DefaultClient, "http://127.0.0.1:1")
+	if _, err := p.LightBlock(context.Background(), 1); err != provider.ErrNoResponse {
+		t.Fatalf("unreachable upstream error = %v, want %v", err, provider.ErrNoResponse)
+	}
+}
+
+// TestProviderChecksHeight 检查上游返回其他高度的有效区块时 provider 报告错误的轻区块
+func TestProviderChecksHeight(t *testing.T) {
+	c := newTestChain(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":%s}`, c.marshal(t, c.result(method, testHeight)))
+	}))
+	defer srv.Close()
+	p := NewHTTPProvider(testChainID, srv.Client(), srv.URL)
+	if _, err := p.LightBlock(context.Background(), testHeight); err != nil {
+		t.Fatalf("LightBlock(%d) error = %v", testHeight, err)
+	}
+	_, err := p.LightBlock(context.Background(), testHeight-1)
+	if _, ok := err.(provider.ErrBadLightBlock); !ok {
+		t.Fatalf("LightBlock(%d) error = %v, want ErrBadLightBlock", testHeight-1, err)
+	}
+}
@@ -0,0 +1,404 @@
+// Package lightclient 用 CometBFT 轻客户端验证上游返回的区块、验证者和查询证明，
+// 区块头从可信高度和哈希出发按顺序或跳跃验证
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/merkle"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	cmtmath "github.com/cometbft/cometbft/libs/math"
+	"github.com/cometbft/cometbft/light"
+	"github.com/cometbft/cometbft/light/provider"
+	dbs "github.com/cometbft/cometbft/light/store/db"
+	"github.com/cometbft/cometbft/types"
+)
+
+// methods 是会被验证的 RPC 方法
+var methods = map[string]bool{
+	"commit":        true,
+	"validators":    true,
+	"block":         true,
+	"block_results": true,
+	"abci_query":    true,
+}
+
+// Verifies 判断 method 的结果是否可能需要验证
+func Verifies(method string) bool {
+	return methods[method]
+}
+
+// NeedsVerification 判断调用的结果是否需要验证，没有要求证明的 abci_query 无法验证
+func NeedsVerification(method string, params map[string]string) bool {
+	if method == "abci_query" {
+		prove, _ := strconv.ParseBool(params["prove"])
+		return prove
+	}
+	return methods[method]
+}
+
+// storeNamePattern 从 abci_query 路径中取出 store 名，例如 /store/bank/key
+var storeNamePattern = regexp.MustCompile(`/store/(.+)/key`)
+
+// Config 是轻客户端的信任参数
+type Config struct {
+	ChainID string
+	// TrustedHeight 和 TrustedHash 是通过可信渠道获得的区块，验证从这里开始
+	TrustedHeight int64
+	TrustedHash   []byte
+	// TrustingPeriod 应明显短于链的解绑期
+	TrustingPeriod time.Duration
+	// TrustLevel 是跳跃验证时需要签名的旧验证者集合比例
+	TrustLevel cmtmath.Fraction
+	// Sequential 为 true 时逐个验证中间的区块头，否则跳跃验证
+	Sequential bool
+}
+
+// DefaultConfig 返回默认的信任参数，需要另外设置链 ID 和可信区块
+func DefaultConfig() Config {
+	return Config{
+		TrustingPeriod: 168 * time.Hour,
+		TrustLevel:     light.DefaultTrustLevel,
+	}
+}
+
+// Verifier 验证上游的响应，可以并发调用
+type Verifier struct {
+	// mu 串行化获取和验证新区块头的 light.Client 调用，它更新可信区块时没有加锁；
+	// 已验证的区块头从并发安全的 store 中读取，结果的验证和交叉检查也不需要加锁
+	mu     sync.Mutex
+	client *light.Client
+	// sources 是 primary 和 witnesses 中的 HTTP 上游，用于交叉检查区块头没有覆盖的结果
+	sources []*httpProvider
+	// Proofs 用于验证 abci_query 的证明，默认支持 simple:v 和 ics23 证明，
+	// 可以注册应用自己的证明类型
+	Proofs *merkle.ProofRuntime
+}
+
+// New 创建轻客户端并取得可信区块。primary 是首选的数据源，
+// witnesses 用于交叉检查，至少需要一个，primary 失败时从中选出新的 primary
+func New(ctx context.Context, cfg Config, primary provider.Provider, witnesses []provider.Provider) (*Verifier, error) {
+	mode := light.SkippingVerification(cfg.TrustLevel)
+	if cfg.Sequential {
+		mode = light.SequentialVerification()
+	}
+	client, err := light.NewClient(ctx, cfg.ChainID,
+		light.TrustOptions{Period: cfg.TrustingPeriod, Height: cfg.TrustedHeight, Hash: cfg.TrustedHash},
+		primary, witnesses, dbs.New(dbm.NewMemDB(), cfg.ChainID), mode)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{client: client, Proofs: ProofRuntime()}
+	for _, p := range append([]provider.Provider{primary}, witnesses...) {
+		if hp, ok := p.(*httpProvider); ok {
+			v.sources = append(v.sources, hp)
+		}
+	}
+	return v, nil
+}
+
+// LightBlock 返回已验证的 height 区块头和验证者集合，未验证过时从 primary 获取并验证
+func (v *Verifier) LightBlock(ctx context.Context, height int64) (*types.LightBlock, error) {
+	if height > 0 {
+		if lb, err := v.client.TrustedLightBlock(height); err == nil {
+			return lb, nil
+		}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	lb, err := v.client.VerifyLightBlockAtHeight(ctx, height, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("verifying header at height %d: %w", height, err)
+	}
+	return lb, nil
+}
+
+// Verify 验证 method 调用的结果。params 是调用的命名参数，结果必须是请求的高度和键的结果；
+// source 是返回结果的上游地址，交叉检查时不再向它请求。不需要验证的调用直接通过
+func (v *Verifier) Verify(ctx context.Context, source, method string, params map[string]string, result json.RawMessage) error {
+	var height int64
+	if s, ok := params["height"]; ok {
+		var err error
+		if height, err = strconv.ParseInt(s, 10, 64); err != nil || height < 0 {
+			return fmt.Errorf("invalid height %q", s)
+		}
+	}
+	switch method {
+	case "commit":
+		return v.verifyCommit(ctx, height, result)
+	case "validators":
+		return v.verifyValidators(ctx, height, result)
+	case "block":
+		return v.verifyBlock(ctx, height, result)
+	case "block_results":
+		return v.verifyBlockResults(ctx, source, height, result)
+	case "abci_query":
+		return v.verifyABCIQuery(ctx, height, params, result)
+	}
+	return nil
+}
+
+// checkHeight 要求结果的高度与请求的高度一致，没有指定高度时请求的是最新高度，不做检查
+func checkHeight(requested, got int64) error {
+	if requested > 0 && got != requested {
+		return fmt.Errorf("result is for height %d, requested height %d", got, requested)
+	}
+	return nil
+}
+
+// verifyCommit 要求签名区块头与已验证的区块头一致，且提交中有超过 2/3 投票权的
+// 已验证验证者对该区块头签名。哈希一致只说明区块头可信，提交中的签名仍可能是伪造的
+func (v *Verifier) verifyCommit(ctx context.Context, height int64, result json.RawMessage) error {
+	var commit resultCommit
+	if err := cmtjson.Unmarshal(result, &commit); err != nil {
+		return fmt.Errorf("invalid commit result: %v", err)
+	}
+	sh := commit.SignedHeader
+	if sh.Header == nil || sh.Commit == nil {
+		return fmt.Errorf("commit result is missing the header or commit")
+	}
+	if err := sh.ValidateBasic(v.client.ChainID()); err != nil {
+		return fmt.Errorf("invalid signed header: %v", err)
+	}
+	if err := checkHeight(height, sh.Height); err != nil {
+		return err
+	}
+	lb, err := v.LightBlock(ctx, sh.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sh.Hash(), lb.Hash()) {
+		return fmt.Errorf("header hash %X at height %d does not match verified hash %X", sh.Hash(), sh.Height, lb.Hash())
+	}
+	if err := lb.ValidatorSet.VerifyCommitLight(v.client.ChainID(), sh.Commit.BlockID, sh.Height, sh.Commit); err != nil {
+		return fmt.Errorf("invalid commit at height %d: %v", sh.Height, err)
+	}
+	return nil
+}
+
+// verifyValidators 要求返回的每个验证者都在已验证的验证者集合中，公钥和投票权一致。
+// 不带高度时 CometBFT 返回下一个高度的验证者，此时还没有该高度的区块头，
+// 只能用上一个区块头中的 NextValidatorsHash 验证完整的集合
+func (v *Verifier) verifyValidators(ctx context.Context, height int64, result json.RawMessage) error {
+	var vals resultValidators
+	if err := cmtjson.Unmarshal(result, &vals); err != nil {
+		return fmt.Errorf("invalid validators result: %v", err)
+	}
+	if err := checkHeight(height, vals.BlockHeight); err != nil {
+		return err
+	}
+	lb, err := v.LightBlock(ctx, vals.BlockHeight)
+	if errors.Is(err, provider.ErrHeightTooHigh) && vals.BlockHeight > 1 {
+		return v.verifyNextValidators(ctx, vals)
+	}
+	if err != nil {
+		return err
+	}
+	if vals.Total != lb.ValidatorSet.Size() {
+		return fmt.Errorf("validator count %d at height %d does not match verified count %d", vals.Total, vals.BlockHeight, lb.ValidatorSet.Size())
+	}
+	for _, val := range vals.Validators {
+		_, trusted := lb.ValidatorSet.GetByAddress(val.Address)
+		if trusted == nil {
+			return fmt.Errorf("validator %X is not in the verified set at height %d", val.Address, vals.BlockHeight)
+		}
+		if !trusted.PubKey.Equals(val.PubKey) || trusted.VotingPower != val.VotingPower {
+			return fmt.Errorf("validator %X does not match the verified set at height %d", val.Address, vals.BlockHeight)
+		}
+	}
+	return nil
+}
+
+// verifyNextValidators 用 H-1 区块头中的 NextValidatorsHash 验证高度 H 的验证者集合，
+// 分页返回的部分集合无法计算哈希
+func (v *Verifier) verifyNextValidators(ctx context.Context, vals resultValidators) error {
+	if vals.Count != vals.Total {
+		return fmt.Errorf("validators at the latest height %d can only be verified in a single page, got %d of %d", vals.BlockHeight, vals.Count, vals.Total)
+	}
+	prev, err := v.LightBlock(ctx, vals.BlockHeight-1)
+	if err != nil {
+		return err
+	}
+	set, err := types.ValidatorSetFromExistingValidators(vals.Validators)
+	if err != nil {
+		return fmt.Errorf("invalid validator set: %v", err)
+	}
+	if !bytes.Equal(set.Hash(), prev.NextValidatorsHash) {
+		return fmt.Errorf("validator set hash %X at height %d does not match verified hash %X", set.Hash(), vals.BlockHeight, prev.NextValidatorsHash)
+	}
+	return nil
+}
+
+// verifyBlock 检查区块内容与区块头中的哈希一致，且区块头与已验证的区块头一致
+func (v *Verifier) verifyBlock(ctx context.Context, height int64, result json.RawMessage) error {
+	var res struct {
+		BlockID types.BlockID `json:"block_id"`
+		Block   *types.Block  `json:"block"`
+	}
+	if err := cmtjson.Unmarshal(result, &res); err != nil {
+		return fmt.Errorf("invalid block result: %v", err)
+	}
+	if res.Block == nil {
+		return fmt.Errorf("block result is missing the block")
+	}
+	// ValidateBasic 校验交易、上一个提交和证据与区块头中的哈希一致
+	if err := res.Block.ValidateBasic(); err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
+	if err := checkHeight(height, res.Block.Height); err != nil {
+		return err
+	}
+	lb, err := v.LightBlock(ctx, res.Block.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(res.Block.Hash(), lb.Hash()) || !bytes.Equal(res.BlockID.Hash, lb.Hash()) {
+		return fmt.Errorf("block hash %X at height %d does not match verified hash %X", res.Block.Hash(), res.Block.Height, lb.Hash())
+	}
+	return nil
+}
+
+// verifyBlockResults 用 H+1 区块头验证高度 H 的结果：LastResultsHash 覆盖交易结果的 code、data 和 gas，
+// AppHash 是执行区块 H 后的应用状态，NextValidatorsHash 是应用 H 的验证者变更后的集合。
+// 事件、日志和共识参数变更不在任何区块头中，要求另一个上游返回完全相同的结果
+func (v *Verifier) verifyBlockResults(ctx context.Context, source string, height int64, result json.RawMessage) error {
+	var res resultBlockResults
+	if err := cmtjson.Unmarshal(result, &res); err != nil {
+		return fmt.Errorf("invalid block_results result: %v", err)
+	}
+	if res.Height <= 0 {
+		return fmt.Errorf("block_results returned invalid height %d", res.Height)
+	}
+	if err := checkHeight(height, res.Height); err != nil {
+		return err
+	}
+	lb, err := v.LightBlock(ctx, res.Height+1)
+	if err != nil {
+		return fmt.Errorf("results at height %d can't be verified until the next block is available: %w", res.Height, err)
+	}
+	if hash := types.NewResults(res.TxsResults).Hash(); !bytes.Equal(hash, lb.LastResultsHash) {
+		return fmt.Errorf("results hash %X at height %d does not match verified hash %X", hash, res.Height, lb.LastResultsHash)
+	}
+	if !bytes.Equal(res.AppHash, lb.AppHash) {
+		return fmt.Errorf("app hash %X at height %d does not match verified hash %X", res.AppHash, res.Height, lb.AppHash)
+	}
+
+	updates, err := types.PB2TM.ValidatorUpdates(res.ValidatorUpdates)
+	if err != nil {
+		return fmt.Errorf("invalid validator updates: %v", err)
+	}
+	next := lb.ValidatorSet.Copy()
+	if err := next.UpdateWithChangeSet(updates); err != nil {
+		return fmt.Errorf("validator updates at height %d can't be applied to the verified set: %v", res.Height, err)
+	}
+	if !bytes.Equal(next.Hash(), lb.NextValidatorsHash) {
+		return fmt.Errorf("validator updates at height %d do not match verified next validators hash %X", res.Height, lb.NextValidatorsHash)
+	}
+	return v.crossCheckResults(ctx, source, res)
+}
+
+// crossCheckResults 向 source 以外第一个能响应的上游请求同一高度的 block_results，要求编码后完全一致
+func (v *Verifier) crossCheckResults(ctx context.Context, source string, res resultBlockResults) error {
+	ours, err := cmtjson.Marshal(res)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("height", strconv.FormatInt(res.Height, 10))
+	source = strings.TrimSuffix(source, "/")
+	lastErr := fmt.Errorf("no other upstream")
+	for _, p := range v.sources {
+		if p.url == source {
+			continue
+		}
+		var other resultBlockResults
+		if err := p.call(ctx, "block_results", query, &other); err != nil {
+			lastErr = fmt.Errorf("%s: %v", p, err)
+			continue
+		}
+		theirs, err := cmtjson.Marshal(other)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(ours, theirs) {
+			return fmt.Errorf("results at height %d differ from the results returned by %s", res.Height, p)
+		}
+		return nil
+	}
+	return fmt.Errorf("results at height %d can't be cross-checked: %v", res.Height, lastErr)
+}
+
+// verifyABCIQuery 用 H+1 区块头中的 AppHash 验证高度 H 的查询证明，要求了证明却没有返回证明时拒绝，
+// 返回的键和高度必须是请求的键和高度
+func (v *Verifier) verifyABCIQuery(ctx context.Context, height int64, params map[string]string, result json.RawMessage) error {
+	var res struct {
+		Response abci.ResponseQuery `json:"response"`
+	}
+	if err := cmtjson.Unmarshal(result, &res); err != nil {
+		return fmt.Errorf("invalid abci_query result: %v", err)
+	}
+	resp := res.Response
+	if !NeedsVerification("abci_query", params) || resp.IsErr() {
+		return nil
+	}
+	if resp.ProofOps == nil || len(resp.ProofOps.Ops) == 0 {
+		return fmt.Errorf("abci_query returned no proof")
+	}
+	if resp.Height <= 0 {
+		return fmt.Errorf("abci_query returned invalid height %d", resp.Height)
+	}
+	if err := checkHeight(height, resp.Height); err != nil {
+		return err
+	}
+	// 证明只说明返回的键值在状态中，还需要确认返回的是请求的键。data 是十六进制，URI 形式带 0x 前缀
+	data, err := hex.DecodeString(strings.TrimPrefix(params["data"], "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid abci_query data %q: %v", params["data"], err)
+	}
+	if !bytes.Equal(resp.Key, data) {
+		return fmt.Errorf("proof is for key %X, requested key %X", resp.Key, data)
+	}
+
+	lb, err := v.LightBlock(ctx, resp.Height+1)
+	if err != nil {
+		return fmt.Errorf("proof at height %d can't be verified until the next block is available: %w", resp.Height, err)
+	}
+	keyPath, err := queryKeyPath(params["path"], resp.Key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Value) > 0 {
+		err = v.Proofs.VerifyValue(resp.ProofOps, lb.AppHash, keyPath, resp.Value)
+	} else {
+		err = v.Proofs.VerifyAbsence(resp.ProofOps, lb.AppHash, keyPath)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid proof at height %d: %v", resp.Height, err)
+	}
+	return nil
+}
+
+// queryKeyPath 按 /store/<name>/key 形式的路径生成证明的键路径
+func queryKeyPath(path string, key []byte) (string, error) {
+	m := storeNamePattern.FindStringSubmatch(path)
+	if m == nil {
+		return "", fmt.Errorf("can't verify abci_query path %q, only /store/<name>/key paths are supported", path)
+	}
+	var kp merkle.KeyPath
+	kp = kp.AppendKey([]byte(m[1]), merkle.KeyEncodingURL)
+	kp = kp.AppendKey(key, merkle.KeyEncodingURL)
+	return kp.String(), nil
+}
@@ -0,0 +1,491 @@
+package lightclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/merkle"
+	"github.com/cometbft/cometbft/crypto/tmhash"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	"github.com/cometbft/cometbft/light/provider"
+	cmtcrypto "github.com/cometbft/cometbft/proto/tendermint/crypto"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	cmtversion "github.com/cometbft/cometbft/proto/tendermint/version"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/cometbft/version"
+	ics23 "github.com/cosmos/ics23/go"
+)
+
+const (
+	testChainID    = "test-chain"
+	testHeight     = 10
+	testValidators = 4
+)
+
+// testStores 是每个高度都相同的应用状态，AppHash 是 store 根哈希构成的 multistore 的根哈希
+var testStores = map[string]map[string][]byte{
+	"bank": {"a": []byte("1"), "b": []byte("2"), "c": []byte("3")},
+	"acc":  {"x": []byte("9")},
+}
+
+// testChain 是由几个验证者签名的链，所有区块使用同一个验证者集合
+type testChain struct {
+	privVals []types.PrivValidator
+	valSet   *types.ValidatorSet
+	blocks   map[int64]*types.Block
+	blockIDs map[int64]types.BlockID
+	commits  map[int64]*types.Commit
+	results  map[int64][]*abci.ExecTxResult
+	appHash  []byte
+
+	mu        sync.Mutex
+	requested map[string]bool
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	c := &testChain{
+		blocks:    make(map[int64]*types.Block),
+		blockIDs:  make(map[int64]types.BlockID),
+		commits:   make(map[int64]*types.Commit),
+		results:   make(map[int64][]*abci.ExecTxResult),
+		requested: make(map[string]bool),
+	}
+	for i := 0; i < testValidators; i++ {
+		c.privVals = append(c.privVals, types.NewMockPV())
+	}
+	// MakeExtCommit 按下标签名，私钥的顺序要与验证者集合一致
+	sort.Sort(types.PrivValidatorsByAddress(c.privVals))
+	var vals []*types.Validator
+	for _, pv := range c.privVals {
+		vals = append(vals, pv.(types.MockPV).ExtractIntoValidator(10))
+	}
+	c.valSet = types.NewValidatorSet(vals)
+
+	appHash, _ := multistoreProof(t, "bank", "a")
+	c.appHash = appHash
+	base := time.Now().Add(-time.Hour).UTC()
+	for h := int64(1); h <= testHeight; h++ {
+		lastCommit := &types.Commit{}
+		var lastBlockID types.BlockID
+		var lastResultsHash []byte
+		if h > 1 {
+			lastCommit = c.commits[h-1]
+			lastBlockID = c.blockIDs[h-1]
+			lastResultsHash = types.NewResults(c.results[h-1]).Hash()
+		}
+		block := types.MakeBlock(h, []types.Tx{types.Tx(fmt.Sprintf("k%d=v%d", h, h))}, lastCommit, nil)
+		block.Header.Populate(cmtversion.Consensus{Block: version.BlockProtocol}, testChainID,
+			base.Add(time.Duration(h)*time.Minute), lastBlockID, c.valSet.Hash(), c.valSet.Hash(),
+			tmhash.Sum([]byte("params")), appHash, lastResultsHash, c.valSet.Proposer.Address)
+		parts, err := block.MakePartSet(types.BlockPartSizeBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockID := types.BlockID{Hash: block.Hash(), PartSetHeader: parts.Header()}
+		voteSet := types.NewVoteSet(testChainID, h, 0, cmtproto.PrecommitType, c.valSet)
+		extCommit, err := types.MakeExtCommit(blockID, h, 0, voteSet, c.privVals, block.Time, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.blocks[h] = block
+		c.blockIDs[h] = blockID
+		c.commits[h] = extCommit.ToCommit()
+		c.results[h] = []*abci.ExecTxResult{{Data: []byte(fmt.Sprintf("ok %d", h))}}
+	}
+	return c
+}
+
+// result 返回 method 在 height 的结果，与 CometBFT RPC 返回的结构一致
+func (c *testChain) result(method string, height int64) any {
+	switch method {
+	case "commit":
+		return resultCommit{
+			SignedHeader: types.SignedHeader{Header: &c.blocks[height].Header, Commit: c.commits[height]},
+			Canonical:    true,
+		}
+	case "validators":
+		return resultValidators{BlockHeight: height, Validators: c.valSet.Validators, Count: testValidators, Total: testValidators}
+	case "block":
+		return struct {
+			BlockID types.BlockID `json:"block_id"`
+			Block   *types.Block  `json:"block"`
+		}{c.blockIDs[height], c.blocks[height]}
+	case "block_results":
+		return resultBlockResults{
+			Height:              height,
+			TxsResults:          c.results[height],
+			FinalizeBlockEvents: []abci.Event{{Type: "mint", Attributes: []abci.EventAttribute{{Key: "amount", Value: "1"}}}},
+			AppHash:             c.appHash,
+		}
+	}
+	return nil
+}
+
+func (c *testChain) marshal(t *testing.T, v any) []byte {
+	t.Helper()
+	bz, err := cmtjson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bz
+}
+
+// serve 用 httptest 提供 URI 形式的 RPC，并记录请求过的方法和高度
+func (c *testChain) serve(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		height := int64(testHeight)
+		if s := r.URL.Query().Get("height"); s != "" {
+			height, _ = strconv.ParseInt(s, 10, 64)
+		}
+		c.mu.Lock()
+		c.requested[fmt.Sprintf("%s/%d", method, height)] = true
+		c.mu.Unlock()
+
+		if height > testHeight {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error","data":"height %d must be less than or equal to the current blockchain height %d"}}`, height, testHeight)
+			return
+		}
+		result := c.result(method, height)
+		if result == nil {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":%s}`, c.marshal(t, result))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func (c *testChain) wasRequested(method string, height int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requested[fmt.Sprintf("%s/%d", method, height)]
+}
+
+func (c *testChain) verifier(t *testing.T, sequential bool) *Verifier {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.ChainID = testChainID
+	cfg.TrustedHeight = 1
+	cfg.TrustedHash = c.blocks[1].Hash()
+	cfg.Sequential = sequential
+	client := &http.Client{Timeout: 5 * time.Second}
+	primary := NewHTTPProvider(testChainID, client, c.serve(t))
+	witness := NewHTTPProvider(testChainID, client, c.serve(t))
+	v, err := New(context.Background(), cfg, primary, []provider.Provider{witness})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// simpleProof 返回 kvs 构成的简单默克尔树的根哈希和 key 的 ics23:simple 存在证明
+func simpleProof(t *testing.T, kvs map[string][]byte, key string) ([]byte, cmtcrypto.ProofOp) {
+	t.Helper()
+	var keys []string
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var leaves [][]byte
+	index := -1
+	for i, k := range keys {
+		valueHash := sha256.Sum256(kvs[k])
+		leaf := binary.AppendUvarint(nil, uint64(len(k)))
+		leaf = append(leaf, k...)
+		leaf = binary.AppendUvarint(leaf, uint64(len(valueHash)))
+		leaves = append(leaves, append(leaf, valueHash[:]...))
+		if k == key {
+			index = i
+		}
+	}
+	if index < 0 {
+		t.Fatalf("key %q not in store", key)
+	}
+	root, proofs := merkle.ProofsFromByteSlices(leaves)
+	p := proofs[index]
+	exist := &ics23.ExistenceProof{
+		Key:   []byte(key),
+		Value: kvs[key],
+		Leaf:  ics23.TendermintSpec.LeafSpec,
+		Path:  innerOps(p.Index, p.Total, p.Aunts),
+	}
+	data, err := (&ics23.CommitmentProof{Proof: &ics23.CommitmentProof_Exist{Exist: exist}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, cmtcrypto.ProofOp{Type: "ics23:simple", Key: []byte(key), Data: data}
+}
+
+// innerOps 把默克尔证明的兄弟节点按从叶子到根的顺序转换为 ics23 的内部节点
+func innerOps(index, total int64, aunts [][]byte) []*ics23.InnerOp {
+	if total <= 1 {
+		return nil
+	}
+	numLeft := int64(1)
+	for numLeft*2 < total {
+		numLeft *= 2
+	}
+	aunt := aunts[len(aunts)-1]
+	if index < numLeft {
+		op := &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: []byte{1}, Suffix: aunt}
+		return append(innerOps(index, numLeft, aunts[:len(aunts)-1]), op)
+	}
+	op := &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: append([]byte{1}, aunt...)}
+	return append(innerOps(index-numLeft, total-numLeft, aunts[:len(aunts)-1]), op)
+}
+
+// multistoreProof 返回 AppHash 和 store 中 key 的两步证明，与 Cosmos SDK 的证明结构一致
+func multistoreProof(t *testing.T, store, key string) ([]byte, *cmtcrypto.ProofOps) {
+	t.Helper()
+	roots := make(map[string][]byte)
+	var keyOp cmtcrypto.ProofOp
+	for name, kvs := range testStores {
+		if name == store {
+			roots[name], keyOp = simpleProof(t, kvs, key)
+		} else {
+			roots[name], _ = simpleProof(t, kvs, firstKey(kvs))
+		}
+	}
+	appHash, storeOp := simpleProof(t, roots, store)
+	return appHash, &cmtcrypto.ProofOps{Ops: []cmtcrypto.ProofOp{keyOp, storeOp}}
+}
+
+func firstKey(kvs map[string][]byte) string {
+	for k := range kvs {
+		return k
+	}
+	return ""
+}
+
+func TestVerifyHeaders(t *testing.T) {
+	for _, sequential := range []bool{true, false} {
+		t.Run(fmt.Sprintf("sequential=%v", sequential), func(t *testing.T) {
+			c := newTestChain(t)
+			v := c.verifier(t, sequential)
+			ctx := context.Background()
+			for _, method := range []string{"commit", "validators", "block"} {
+				if err := v.Verify(ctx, "", method, nil, c.marshal(t, c.result(method, testHeight))); err != nil {
+					t.Fatalf("%s at height %d: %v", method, testHeight, err)
+				}
+			}
+			// 顺序验证需要获取每个中间区块头，跳跃验证时验证者集合不变，可以直接从可信区块跳到最新区块
+			if got := c.wasRequested("commit", testHeight/2); got != sequential {
+				t.Errorf("intermediate header requested = %v, want %v", got, sequential)
+			}
+			if err := v.Verify(ctx, "", "block_results", nil, c.marshal(t, c.result("block_results", testHeight-1))); err != nil {
+				t.Fatalf("block_results: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedResults(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	const height = testHeight / 2
+
+	forged := *c.commits[height]
+	forged.Signatures = make([]types.CommitSig, len(c.commits[height].Signatures))
+	for i, sig := range c.commits[height].Signatures {
+		sig.Signature = append([]byte(nil), sig.Signature...)
+		sig.Signature[0] ^= 0xff
+		forged.Signatures[i] = sig
+	}
+
+	evilData := types.Data{Txs: []types.Tx{types.Tx("k5=evil")}}
+	tamperedTx := &types.Block{Header: c.blocks[height].Header, Data: evilData, LastCommit: c.commits[height-1]}
+	rehashed := &types.Block{Header: c.blocks[height].Header, Data: evilData, LastCommit: c.commits[height-1]}
+	rehashed.DataHash = evilData.Hash()
+
+	tamperedResults := []*abci.ExecTxResult{{Data: []byte("evil")}}
+
+	tests := []struct {
+		name   string
+		method string
+		result any
+	}{
+		{"forged commit signatures", "commit", resultCommit{
+			SignedHeader: types.SignedHeader{Header: &c.blocks[height].Header, Commit: &forged},
+			Canonical:    true,
+		}},
+		{"tampered block tx", "block", struct {
+			BlockID types.BlockID `json:"block_id"`
+			Block   *types.Block  `json:"block"`
+		}{c.blockIDs[height], tamperedTx}},
+		{"tampered block header", "block", struct {
+			BlockID types.BlockID `json:"block_id"`
+			Block   *types.Block  `json:"block"`
+		}{types.BlockID{Hash: rehashed.Hash(), PartSetHeader: c.blockIDs[height].PartSetHeader}, rehashed}},
+		{"tampered block_results", "block_results", resultBlockResults{Height: height, TxsResults: tamperedResults, AppHash: c.appHash}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Verify(context.Background(), "", tt.method, nil, c.marshal(t, tt.result)); err == nil {
+				t.Fatal("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestVerifyABCIQuery(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	_, proof := multistoreProof(t, "bank", "a")
+
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		proof   *cmtcrypto.ProofOps
+		wantErr bool
+	}{
+		{"valid proof", "/store/bank/key", "1", proof, false},
+		{"tampered value", "/store/bank/key", "2", proof, true},
+		{"wrong store", "/store/acc/key", "1", proof, true},
+		{"missing proof", "/store/bank/key", "1", nil, true},
+		{"unsupported path", "/custom/bank/balance", "1", proof, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := struct {
+				Response abci.ResponseQuery `json:"response"`
+			}{abci.ResponseQuery{Key: []byte("a"), Value: []byte(tt.value), ProofOps: tt.proof, Height: testHeight - 1}}
+			params := map[string]string{"path": tt.path, "data": "61", "prove": "true"}
+			err := v.Verify(context.Background(), "", "abci_query", params, c.marshal(t, result))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksRequestedHeight(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	const height = testHeight / 2
+	for _, method := range []string{"commit", "validators", "block", "block_results"} {
+		t.Run(method, func(t *testing.T) {
+			result := c.marshal(t, c.result(method, height))
+			if err := v.Verify(context.Background(), "", method, map[string]string{"height": strconv.Itoa(height)}, result); err != nil {
+				t.Fatalf("result for the requested height: %v", err)
+			}
+			// 另一个高度的有效结果不能作为请求高度的结果
+			if err := v.Verify(context.Background(), "", method, map[string]string{"height": strconv.Itoa(height + 1)}, result); err == nil {
+				t.Fatal("result for another height accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyBlockResults(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	const height = testHeight / 2
+	valid := c.result("block_results", height).(resultBlockResults)
+
+	newVal := types.NewMockPV().ExtractIntoValidator(10)
+	tests := []struct {
+		name    string
+		tamper  func(*resultBlockResults)
+		wantErr string // 错误信息中应包含的内容，为空表示验证通过
+	}{
+		{"valid", func(*resultBlockResults) {}, ""},
+		{"tampered app hash", func(r *resultBlockResults) { r.AppHash = tmhash.Sum([]byte("evil")) }, "app hash"},
+		{"tampered validator updates", func(r *resultBlockResults) {
+			r.ValidatorUpdates = []abci.ValidatorUpdate{types.TM2PB.ValidatorUpdate(newVal)}
+		}, "validator updates"},
+		// 事件和共识参数变更不在区块头中，由另一个上游交叉检查
+		{"tampered events", func(r *resultBlockResults) { r.FinalizeBlockEvents[0].Attributes[0].Value = "1000" }, "differ"},
+		{"dropped events", func(r *resultBlockResults) { r.FinalizeBlockEvents = nil }, "differ"},
+		{"tampered tx log", func(r *resultBlockResults) { r.TxsResults[0].Log = "evil" }, "differ"},
+		{"tampered consensus params", func(r *resultBlockResults) {
+			r.ConsensusParamUpdates = &cmtproto.ConsensusParams{Block: &cmtproto.BlockParams{MaxBytes: 1, MaxGas: -1}}
+		}, "differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 复制一份，避免修改 testChain 中的结果
+			var res resultBlockResults
+			if err := cmtjson.Unmarshal(c.marshal(t, valid), &res); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&res)
+			// 结果来自 primary，交叉检查只能发往 witness
+			err := v.Verify(context.Background(), v.sources[0].url, "block_results", nil, c.marshal(t, res))
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if !c.wasRequested("block_results", height) {
+		t.Error("results were not cross-checked with another upstream")
+	}
+}
+
+func TestVerifyABCIQueryRequest(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	_, proof := multistoreProof(t, "bank", "a")
+	result := c.marshal(t, struct {
+		Response abci.ResponseQuery `json:"response"`
+	}{abci.ResponseQuery{Key: []byte("a"), Value: []byte("1"), ProofOps: proof, Height: testHeight - 1}})
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr bool
+	}{
+		{"requested key", map[string]string{"data": "61"}, false},
+		{"uri hex key", map[string]string{"data": "0x61"}, false},
+		{"requested height", map[string]string{"data": "61", "height": strconv.Itoa(testHeight - 1)}, false},
+		{"proof for another key", map[string]string{"data": "62"}, true},
+		{"missing key", map[string]string{}, true},
+		{"proof for another height", map[string]string{"data": "61", "height": strconv.Itoa(testHeight - 2)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params["path"] = "/store/bank/key"
+			tt.params["prove"] = "true"
+			err := v.Verify(context.Background(), "", "abci_query", tt.params, result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyConcurrent 并发验证不同高度的结果，用 -race 运行
+func TestVerifyConcurrent(t *testing.T) {
+	c := newTestChain(t)
+	v := c.verifier(t, false)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for h := int64(2); h < testHeight; h++ {
+				method := []string{"commit", "block", "validators"}[(int(h)+i)%3]
+				if err := v.Verify(context.Background(), "", method, nil, c.marshal(t, c.result(method, h))); err != nil {
+					t.Errorf("%s at height %d: %v", method, h, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
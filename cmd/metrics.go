@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 代理的 Prometheus 指标，通过 --admin-port 上的 /metrics 导出
+var (
+	metricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_requests_total",
+		Help: "JSON-RPC calls handled by the proxy, by method, upstream and HTTP status.",
+	}, []string{"method", "upstream", "status"})
+
+	metricRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tedtool_request_duration_seconds",
+		Help:    "Time to answer a JSON-RPC call, by method and upstream.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"method", "upstream"})
+
+	metricInflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tedtool_inflight_requests",
+		Help: "HTTP requests currently being handled.",
+	})
+
+	metricRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_rejections_total",
+		Help: "Requests rejected before reaching an upstream, by reason.",
+	}, []string{"reason"})
+
+	metricUpstreamHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tedtool_upstream_healthy",
+		Help: "Whether an upstream is currently considered healthy (1) or not (0).",
+	}, []string{"upstream"})
+
+	metricUpstreamHeight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tedtool_upstream_height",
+		Help: "Latest block height reported by an upstream's /status.",
+	}, []string{"upstream"})
+
+	metricUpstreamLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tedtool_upstream_latency_ewma_seconds",
+		Help: "Exponentially weighted moving average of upstream response latency.",
+	}, []string{"upstream"})
+
+	metricUpstreamFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_upstream_failures_total",
+		Help: "Failed upstream attempts, including retried ones.",
+	}, []string{"upstream"})
+
+	metricUpstreamSwitches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_upstream_switches_total",
+		Help: "Switches of the current upstream in the proxys rotation.",
+	}, []string{"from", "to"})
+
+	metricBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tedtool_upstream_breaker_state",
+		Help: "Circuit breaker state of an upstream: 0 closed, 1 half-open, 2 open.",
+	}, []string{"upstream"})
+
+	metricBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_upstream_breaker_transitions_total",
+		Help: "Circuit breaker state transitions of an upstream.",
+	}, []string{"upstream", "from", "to"})
+
+	metricHedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_hedged_requests_total",
+		Help: "Hedged requests, by whether the hedge was fired or won.",
+	}, []string{"outcome"})
+
+	metricQuorum = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_quorum_requests_total",
+		Help: "Quorum reads, by outcome (agreed, mismatch, insufficient).",
+	}, []string{"outcome"})
+
+	metricVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_verifications_total",
+		Help: "Light client verifications of upstream responses, by method and result.",
+	}, []string{"method", "result"})
+
+	metricBroadcasts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_broadcast_fanout_total",
+		Help: "Fanned-out transaction broadcasts, by upstream and result (accepted, duplicate, rejected, failed).",
+	}, []string{"upstream", "result"})
+
+	metricCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_cache_requests_total",
+		Help: "Cache lookups by result: hit, disk_hit or miss.",
+	}, []string{"result"})
+
+	metricCoalesce = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tedtool_coalesce_requests_total",
+		Help: "Requests eligible for coalescing, by whether they shared another request's upstream call.",
+	}, []string{"shared"})
+)
+
+// metricMethod 将方法名限制在已知方法内，避免客户端随意构造的方法名撑大标签基数
+func (h *proxyHandler) metricMethod(method string) string {
+	if _, ok := h.opts.Costs.Methods[method]; ok {
+		return method
+	}
+	return "other"
+}
+
+// serveMetrics 在单独的管理端口上导出 /metrics，ctx 结束时关闭
+func serveMetrics(ctx context.Context, port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("Metrics listening on %s/metrics", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Metrics server failed: %v", err)
+	}
+}
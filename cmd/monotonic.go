@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tedtool/cmd/peer"
+)
+
+const (
+	// minHeightHeader 是客户端要求上游至少达到的区块高度，用于保证先后读取的高度不倒退
+	minHeightHeader = "X-Min-Height"
+	// heightPollInterval 是等待上游达到最低高度时刷新高度的间隔
+	heightPollInterval = 500 * time.Millisecond
+)
+
+// parseMinHeight 读取客户端要求的最低高度，没有该请求头时返回 0
+func parseMinHeight(r *http.Request) (int64, error) {
+	v := r.Header.Get(minHeightHeader)
+	if v == "" {
+		return 0, nil
+	}
+	height, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || height < 0 {
+		return 0, fmt.Errorf("invalid height %q", v)
+	}
+	return height, nil
+}
+
+// waitForHeight 等待直到有上游满足 rt 的高度要求，期间定期刷新各上游的高度，
+// 超过 MinHeightWait 或客户端断开时返回错误
+func (h *proxyHandler) waitForHeight(ctx context.Context, rt route) error {
+	ctx, cancel := context.WithTimeout(ctx, h.opts.MinHeightWait)
+	defer cancel()
+	for {
+		if h.pool.eligible(rt) > 0 {
+			return nil
+		}
+		h.pool.RefreshHeights(ctx, h.client, rt.MinLatest)
+		if h.pool.eligible(rt) > 0 {
+			return nil
+		}
+		select {
+		case <-time.After(heightPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("no upstream reached height %d within %v, best known height is %d",
+				rt.MinLatest, h.opts.MinHeightWait, h.pool.BestHeight())
+		}
+	}
+}
+
+// learnHeights 从转发的 status 响应中更新上游的高度
+func learnHeights(u *Upstream, body []byte) {
+	var resp struct {
+		Result peer.Result2 `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return
+	}
+	recordSyncInfo(u, resp.Result.SyncInfo)
+}
+
+// latestResult 是最新状态结果中带有区块高度的字段，CometBFT 将 int64 编码为字符串
+type latestResult struct {
+	SyncInfo struct {
+		LatestBlockHeight string `json:"latest_block_height"`
+	} `json:"sync_info"`
+	Response struct {
+		LastBlockHeight string `json:"last_block_height"`
+	} `json:"response"`
+	Block struct {
+		Header struct {
+			Height string `json:"height"`
+		} `json:"header"`
+	} `json:"block"`
+	Header struct {
+		Height string `json:"height"`
+	} `json:"header"`
+	SignedHeader struct {
+		Header struct {
+			Height string `json:"height"`
+		} `json:"header"`
+	} `json:"signed_header"`
+	BlockHeight string `json:"block_height"`
+	Height      string `json:"height"`
+}
+
+// latestHeight 返回读取最新状态的调用结果所在的区块高度，其他调用或无法识别时返回 0
+func latestHeight(call RPCCall, result json.RawMessage) int64 {
+	if !readsLatestState(&RPCRequest{Calls: []RPCCall{call}}) {
+		return 0
+	}
+	var r latestResult
+	if err := json.Unmarshal(result, &r); err != nil {
+		return 0
+	}
+	var v string
+	switch call.Method {
+	case "status":
+		v = r.SyncInfo.LatestBlockHeight
+	case "abci_info":
+		v = r.Response.LastBlockHeight
+	case "block":
+		v = r.Block.Header.Height
+	case "header":
+		v = r.Header.Height
+	case "commit":
+		v = r.SignedHeader.Header.Height
+	case "validators", "consensus_params":
+		v = r.BlockHeight
+	case "block_results":
+		v = r.Height
+	}
+	height, _ := strconv.ParseInt(v, 10, 64)
+	return height
+}
+
+// recordServed 记录响应中返回给客户端的最新高度，之后读取最新状态的调用不会再被发往低于它的上游
+func (h *proxyHandler) recordServed(rpc *RPCRequest, resp *upstreamResponse) {
+	if resp.Status != http.StatusOK || !readsLatestState(rpc) {
+		return
+	}
+	responses, err := decodeRPCResponses(resp.Body)
+	if err != nil {
+		return
+	}
+	calls := make(map[string]RPCCall, len(rpc.Calls))
+	for _, call := range rpc.Calls {
+		calls[string(call.ID)] = call
+	}
+	var best int64
+	for _, r := range responses {
+		call, ok := calls[string(r.ID)]
+		if !ok && !rpc.Batch {
+			call, ok = rpc.Calls[0], true
+		}
+		if !ok || r.Error != nil {
+			continue
+		}
+		if height := latestHeight(call, r.Result); height > best {
+			best = height
+		}
+	}
+	if best > 0 {
+		h.pool.RecordServed(resp.Upstream, best)
+	}
+}
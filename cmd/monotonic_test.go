@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestLatestHeight(t *testing.T) {
+	tests := []struct {
+		method string
+		params string
+		result string
+		want   int64
+	}{
+		{"status", `{}`, `{"sync_info":{"latest_block_height":"1001"}}`, 1001},
+		{"abci_info", `{}`, `{"response":{"last_block_height":"1001"}}`, 1001},
+		{"block", `{}`, `{"block":{"header":{"height":"1001"}}}`, 1001},
+		{"header", `{}`, `{"header":{"height":"1001"}}`, 1001},
+		{"commit", `{}`, `{"signed_header":{"header":{"height":"1001"}}}`, 1001},
+		{"validators", `{}`, `{"block_height":"1001"}`, 1001},
+		{"block_results", `{}`, `{"height":"1001"}`, 1001},
+		{"block", `{"height":"5"}`, `{"block":{"header":{"height":"5"}}}`, 0},
+		{"health", `{}`, `{}`, 0},
+		{"status", `{}`, `[]`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.params, func(t *testing.T) {
+			call := RPCCall{Method: tt.method, Params: json.RawMessage(tt.params)}
+			if got := latestHeight(call, json.RawMessage(tt.result)); got != tt.want {
+				t.Errorf("latestHeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLatestReadsNeverGoBack 检查读取最新状态的调用不会返回比已返回过的更低的高度，
+// 即使落后的上游的已知高度还没有更新
+func TestLatestReadsNeverGoBack(t *testing.T) {
+	statusAt := func(height int64) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"sync_info":{"latest_block_height":"%d"}}}`, height)
+		}
+	}
+	ahead := newTestUpstream(t, statusAt(1001))
+	behind := newTestUpstream(t, statusAt(998))
+	h := newTestHandler(t, []*testUpstream{behind, ahead}, func(opts *proxyOptions) {
+		opts.MaxReadLag = 0
+	})
+	h.pool.Strategy = &roundRobinStrategy{}
+	// 健康检查得到的高度，之后落后的上游的已知高度不再更新
+	h.pool.Upstreams()[0].SetHeights(998, 1)
+	h.pool.Upstreams()[1].SetHeights(1001, 1)
+
+	var last int64
+	for i := 0; i < 10; i++ {
+		w := serve(h, http.MethodGet, "/status", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d: %s", i, w.Code, w.Body)
+		}
+		var resp RPCResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		height := latestHeight(RPCCall{Method: "status"}, resp.Result)
+		if height < last {
+			t.Fatalf("request %d: height went back from %d to %d", i, last, height)
+		}
+		last = height
+	}
+	if last != 1001 {
+		t.Errorf("last height = %d, want 1001", last)
+	}
+	if n := len(behind.received()); n > 1 {
+		t.Errorf("upstream behind the served height received %d requests, want at most 1", n)
+	}
+	if got := h.pool.ServedHeight(); got != 1001 {
+		t.Errorf("ServedHeight() = %d, want 1001", got)
+	}
+}
+
+// TestReadLagAllowsBehindUpstreams 检查 MaxReadLag 内落后的上游仍会被选中
+func TestReadLagAllowsBehindUpstreams(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.MaxReadLag = 5
+	})
+	h.pool.RecordServed(h.pool.Upstreams()[0], 1001)
+	h.pool.Upstreams()[0].SetHeights(996, 1)
+	h.pool.Upstreams()[0].SetHeights(996, 1)
+	if w := serve(h, http.MethodGet, "/status", ""); w.Code != http.StatusOK {
+		t.Errorf("status = %d within the allowed lag: %s", w.Code, w.Body)
+	}
+	if n := len(upstream.received()); n != 1 {
+		t.Errorf("upstream received %d requests, want 1 without refreshing its height", n)
+	}
+}
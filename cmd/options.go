@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"tedtool/cmd/cache"
+	"tedtool/cmd/keys"
+	"tedtool/cmd/lightclient"
+
+	"github.com/spf13/cobra"
+)
+
+// proxyOptions 是 proxy 和 proxys 共用的配置
+type proxyOptions struct {
+	Limit           int
+	IPLimit         BucketConfig
+	KeyLimit        BucketConfig
+	QueueTimeout    time.Duration
+	QueueSize       int
+	TrustedProxies  []*net.IPNet
+	Retries         int
+	BroadcastFanout int
+	Policy          *Policy
+	Costs           *CostTable
+	MaxBatch        int
+	Keys            *keys.Store
+	CacheSize       int64
+	CacheTTL        time.Duration
+	DiskCache       *cache.DiskStore
+	Coalesce        bool
+	WSMaxSubs       int
+	AdminPort       int
+	ShutdownTimeout time.Duration
+	UpstreamTimeout time.Duration
+	Hedge           bool
+	HedgePercentile float64
+	HedgeMinDelay   time.Duration
+	QuorumMethods   []string
+	QuorumSize      int
+	QuorumAgree     int
+	MaxReadLag      int64
+	MinHeightWait   time.Duration
+	TLSCerts        *certReloader
+	TLSClientCAs    *x509.CertPool
+	Verify          *lightclient.Config
+}
+
+// addProxyFlags 定义 proxy 和 proxys 共用的命令行标志
+func addProxyFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.Int("limit", 10, "maximum cost units per second per client IP (a status call costs 1)")
+	flags.Int("burst", 0, "token bucket size in cost units per client IP (default: same as --limit)")
+	flags.Int("key-limit", 50, "maximum cost units per second per API key (X-API-Key header)")
+	flags.Int("key-burst", 0, "token bucket size in cost units per API key (default: same as --key-limit)")
+	flags.String("cost-file", "", "JSON file overriding the per-method cost table")
+	flags.Int("max-batch", 100, "maximum number of calls in a JSON-RPC batch, 0 means unlimited")
+	flags.String("keys-file", "", "API key file managed by 'tedtool keys'; when set, API keys must be valid")
+	flags.String("usage-file", "usage.json", "file where API key usage is written")
+	flags.Duration("queue-timeout", 0, "wait up to this long for tokens instead of returning 429 right away")
+	flags.Int("queue-size", 100, "maximum number of requests waiting for tokens")
+	flags.StringSlice("trusted-proxies", nil, "IPs or CIDRs whose X-Forwarded-For header is trusted")
+	flags.Int("cache-size", 64, "response cache size in MB, 0 disables the cache")
+	flags.Duration("cache-ttl", time.Second, "cache TTL for latest-height queries such as status, 0 disables them")
+	flags.String("disk-cache-dir", "", "directory for the persistent cache of height-pinned responses, empty disables it")
+	flags.Int("disk-cache-size", 1024, "persistent cache size in MB")
+	flags.Int("ws-max-subscriptions", 5, "maximum subscriptions per upstream WebSocket connection, match the nodes' max_subscriptions_per_client; 0 means unlimited")
+	flags.Bool("coalesce", true, "collapse identical in-flight read-only requests into one upstream call")
+	flags.Duration("upstream-timeout", 30*time.Second, "deadline for a single upstream attempt, 0 disables it")
+	flags.Bool("hedge", false, "also send slow read-only requests to a second upstream and use the first response")
+	flags.Float64("hedge-percentile", 0.95, "hedge after this percentile of recent upstream latencies")
+	flags.Duration("hedge-min-delay", 50*time.Millisecond, "minimum delay before hedging, also used until enough latencies are known")
+	flags.Int64("max-read-lag", 0, "send latest-state reads only to upstreams within this many blocks of the highest height already served, negative disables it")
+	flags.Duration("min-height-wait", 5*time.Second, "how long to hold a request whose "+minHeightHeader+" header no upstream has reached yet")
+	flags.Int("admin-port", 0, "port for the admin server exposing /metrics, 0 disables it")
+	flags.String("tls-cert", "", "TLS certificate file, reloaded when it changes; enables HTTPS together with --tls-key")
+	flags.String("tls-key", "", "TLS private key file")
+	flags.String("tls-client-ca", "", "CA bundle for verifying client certificates (mTLS), requires --tls-cert")
+	flags.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests on SIGTERM/SIGINT before closing connections")
+	flags.Bool("verify", false, "verify commit, validators, block, block_results and proven abci_query responses with a light client, requires at least two upstreams")
+	flags.String("chain-id", "", "chain ID checked by the light client")
+	flags.Int64("trusted-height", 0, "height of the trusted header the light client starts from")
+	flags.String("trusted-hash", "", "hex hash of the trusted header at --trusted-height")
+	flags.Duration("trusting-period", lightclient.DefaultConfig().TrustingPeriod, "how long a verified header is trusted, should be well below the unbonding period")
+	flags.String("trust-level", "1/3", "fraction of the trusted validator set that must sign a header when skipping")
+	flags.String("verify-mode", "skipping", "light client header verification: skipping or sequential")
+	flags.StringSlice("allow-methods", nil, "only allow these RPC methods (trailing * matches a prefix)")
+	flags.StringSlice("deny-methods", defaultDeniedMethods, "deny these RPC methods (trailing * matches a prefix)")
+	flags.StringSlice("allow-abci-paths", nil, "only allow abci_query paths with these prefixes")
+	flags.StringSlice("deny-abci-paths", nil, "deny abci_query paths with these prefixes")
+}
+
+// bucketConfig 根据速率和容量生成令牌桶配置，容量为 0 时与速率相同
+func bucketConfig(rate, burst int) BucketConfig {
+	if burst <= 0 {
+		burst = rate
+	}
+	return BucketConfig{Rate: float64(rate), Burst: float64(burst)}
+}
+
+// proxyOptionsFromFlags 读取共用的命令行标志
+func proxyOptionsFromFlags(cmd *cobra.Command) (proxyOptions, error) {
+	flags := cmd.Flags()
+	var opts proxyOptions
+	opts.Limit, _ = flags.GetInt("limit")
+	burst, _ := flags.GetInt("burst")
+	opts.IPLimit = bucketConfig(opts.Limit, burst)
+	keyLimit, _ := flags.GetInt("key-limit")
+	keyBurst, _ := flags.GetInt("key-burst")
+	opts.KeyLimit = bucketConfig(keyLimit, keyBurst)
+	opts.QueueTimeout, _ = flags.GetDuration("queue-timeout")
+	opts.QueueSize, _ = flags.GetInt("queue-size")
+
+	trusted, _ := flags.GetStringSlice("trusted-proxies")
+	var err error
+	if opts.TrustedProxies, err = parseCIDRs(trusted); err != nil {
+		return opts, fmt.Errorf("invalid --trusted-proxies: %v", err)
+	}
+
+	costFile, _ := flags.GetString("cost-file")
+	if opts.Costs, err = loadCostTable(costFile); err != nil {
+		return opts, fmt.Errorf("invalid --cost-file: %v", err)
+	}
+	opts.MaxBatch, _ = flags.GetInt("max-batch")
+
+	cacheSize, _ := flags.GetInt("cache-size")
+	opts.CacheSize = int64(cacheSize) << 20
+	opts.CacheTTL, _ = flags.GetDuration("cache-ttl")
+
+	opts.Coalesce, _ = flags.GetBool("coalesce")
+	opts.WSMaxSubs, _ = flags.GetInt("ws-max-subscriptions")
+	opts.AdminPort, _ = flags.GetInt("admin-port")
+	opts.UpstreamTimeout, _ = flags.GetDuration("upstream-timeout")
+	opts.Hedge, _ = flags.GetBool("hedge")
+	opts.HedgePercentile, _ = flags.GetFloat64("hedge-percentile")
+	opts.HedgeMinDelay, _ = flags.GetDuration("hedge-min-delay")
+	if opts.HedgePercentile <= 0 || opts.HedgePercentile >= 1 {
+		return opts, fmt.Errorf("--hedge-percentile must be between 0 and 1")
+	}
+	opts.MaxReadLag, _ = flags.GetInt64("max-read-lag")
+	opts.MinHeightWait, _ = flags.GetDuration("min-height-wait")
+	opts.ShutdownTimeout, _ = flags.GetDuration("shutdown-timeout")
+
+	tlsCert, _ := flags.GetString("tls-cert")
+	tlsKey, _ := flags.GetString("tls-key")
+	clientCA, _ := flags.GetString("tls-client-ca")
+	if (tlsCert == "") != (tlsKey == "") {
+		return opts, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if clientCA != "" && tlsCert == "" {
+		return opts, fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+	if tlsCert != "" {
+		if opts.TLSCerts, err = newCertReloader(tlsCert, tlsKey); err != nil {
+			return opts, fmt.Errorf("invalid --tls-cert/--tls-key: %v", err)
+		}
+	}
+	if clientCA != "" {
+		if opts.TLSClientCAs, err = loadCertPool(clientCA); err != nil {
+			return opts, fmt.Errorf("invalid --tls-client-ca: %v", err)
+		}
+	}
+
+	if opts.Verify, err = verifyConfigFromFlags(flags); err != nil {
+		return opts, err
+	}
+
+	diskDir, _ := flags.GetString("disk-cache-dir")
+	if diskDir != "" && opts.CacheSize > 0 {
+		diskSize, _ := flags.GetInt("disk-cache-size")
+		if opts.DiskCache, err = cache.OpenDiskStore(diskDir, int64(diskSize)<<20); err != nil {
+			return opts, fmt.Errorf("invalid --disk-cache-dir: %v", err)
+		}
+		entries, bytes := opts.DiskCache.Stats()
+		log.Printf("Loaded disk cache %s: %d entries, %.1f MB", diskDir, entries, float64(bytes)/(1<<20))
+	}
+
+	keysFile, _ := flags.GetString("keys-file")
+	if keysFile != "" {
+		usageFile, _ := flags.GetString("usage-file")
+		if opts.Keys, err = keys.Open(keysFile, usageFile); err != nil {
+			return opts, fmt.Errorf("invalid --keys-file: %v", err)
+		}
+	}
+
+	opts.Policy = &Policy{}
+	opts.Policy.AllowMethods, _ = flags.GetStringSlice("allow-methods")
+	opts.Policy.DenyMethods, _ = flags.GetStringSlice("deny-methods")
+	opts.Policy.AllowABCIPaths, _ = flags.GetStringSlice("allow-abci-paths")
+	opts.Policy.DenyABCIPaths, _ = flags.GetStringSlice("deny-abci-paths")
+	return opts, nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultDeniedMethods 是默认禁止外部调用的方法，包括需要 unsafe 配置的运维接口和开销很大的 genesis
+var defaultDeniedMethods = []string{
+	"dial_seeds",
+	"dial_peers",
+	"unsafe_*",
+	"genesis",
+}
+
+// Policy 按方法名和 abci_query 路径决定是否放行调用。
+// 方法名支持以 * 结尾的前缀匹配，abci_query 路径按前缀匹配；
+// 允许列表非空时只放行列表中的调用，拒绝列表优先于允许列表
+type Policy struct {
+	AllowMethods   []string
+	DenyMethods    []string
+	AllowABCIPaths []string
+	DenyABCIPaths  []string
+}
+
+// matchMethod 判断方法名是否匹配列表中的某一项
+func matchMethod(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		} else if p == method {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath 判断路径是否以列表中的某一项为前缀
+func matchPath(prefixes []string, path string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check 检查单个调用，不允许时返回原因
+func (p *Policy) Check(call RPCCall) error {
+	if p == nil {
+		return nil
+	}
+	if matchMethod(p.DenyMethods, call.Method) {
+		return fmt.Errorf("method %q is denied by proxy policy", call.Method)
+	}
+	if len(p.AllowMethods) > 0 && !matchMethod(p.AllowMethods, call.Method) {
+		return fmt.Errorf("method %q is not in the proxy allowlist", call.Method)
+	}
+
+	if call.Method == "abci_query" {
+		path, _ := call.Param("path")
+		if matchPath(p.DenyABCIPaths, path) {
+			return fmt.Errorf("abci_query path %q is denied by proxy policy", path)
+		}
+		if len(p.AllowABCIPaths) > 0 && !matchPath(p.AllowABCIPaths, path) {
+			return fmt.Errorf("abci_query path %q is not in the proxy allowlist", path)
+		}
+	}
+	return nil
+}
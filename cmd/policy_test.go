@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		DenyMethods:    defaultDeniedMethods,
+		AllowABCIPaths: []string{"/store/bank/", "/cosmos.bank."},
+		DenyABCIPaths:  []string{"/store/bank/secret"},
+	}
+	allowlist := &Policy{AllowMethods: []string{"status", "block*"}, DenyMethods: []string{"block_search"}}
+	tests := []struct {
+		name   string
+		policy *Policy
+		method string
+		params string
+		want   bool
+	}{
+		{"nil policy", nil, "dial_seeds", `{}`, true},
+		{"denied method", policy, "dial_seeds", `{}`, false},
+		{"denied prefix", policy, "unsafe_flush_mempool", `{}`, false},
+		{"other method", policy, "status", `{}`, true},
+		{"allowed abci path", policy, "abci_query", `{"path":"/store/bank/key"}`, true},
+		{"denied abci path", policy, "abci_query", `{"path":"/store/bank/secret/x"}`, false},
+		{"abci path not in allowlist", policy, "abci_query", `{"path":"/store/acc/key"}`, false},
+		{"abci query without path", policy, "abci_query", `{}`, false},
+		{"in allowlist", allowlist, "block_results", `{}`, true},
+		{"not in allowlist", allowlist, "net_info", `{}`, false},
+		{"deny wins over allow", allowlist, "block_search", `{}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(RPCCall{Method: tt.method, Params: json.RawMessage(tt.params)})
+			if (err == nil) != tt.want {
+				t.Errorf("Check() = %v, want allowed %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestPolicyAppliesToForwardedCall 检查策略作用于上游实际执行的调用：
+// CometBFT 对 /<method> 路径不区分 HTTP 方法，请求体也不能改变被调用的方法
+func TestPolicyAppliesToForwardedCall(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"empty post to method path", http.MethodPost, "/unsafe_flush_mempool", "", http.StatusMethodNotAllowed},
+		{"post to method path with allowed body", http.MethodPost, "/dial_seeds?seeds=a@b:1", `{"jsonrpc":"2.0","id":1,"method":"health"}`, http.StatusMethodNotAllowed},
+		{"put to method path", http.MethodPut, "/status", "", http.StatusMethodNotAllowed},
+		{"head to denied method", http.MethodHead, "/dial_seeds?seeds=a@b:1", "", http.StatusForbidden},
+		{"options to denied method", http.MethodOptions, "/unsafe_flush_mempool", "", http.StatusForbidden},
+		{"get to denied method", http.MethodGet, "/dial_peers?peers=a@b:1", "", http.StatusForbidden},
+		{"get to root with denied body", http.MethodGet, "/", `{"jsonrpc":"2.0","id":1,"method":"dial_seeds"}`, http.StatusForbidden},
+		{"denied call in batch", http.MethodPost, "/", `[{"id":1,"method":"health"},{"id":2,"method":"unsafe_flush_mempool"}]`, http.StatusForbidden},
+		{"api key prefix", http.MethodGet, "/key/k/dial_seeds", "", http.StatusForbidden},
+		{"head to allowed method", http.MethodHead, "/status", "", http.StatusOK},
+		{"post to root", http.MethodPost, "/", `{"jsonrpc":"2.0","id":1,"method":"status"}`, http.StatusOK},
+		{"route list", http.MethodPost, "/", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestUpstream(t, nil)
+			h := newTestHandler(t, []*testUpstream{upstream}, nil)
+			w := serve(h, tt.method, tt.target, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			forwarded := len(upstream.received()) > 0
+			if forwarded != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("forwarded = %v, upstream received %q", forwarded, upstream.received())
+			}
+		})
+	}
+}
@@ -2,12 +2,12 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,24 +16,66 @@ var proxysCmd = &cobra.Command{
 	Use:   "proxys",
 	Short: "Start a proxy server with URL fallback support",
 	Long: `Start a proxy server that forwards requests to a list of URLs specified in a file.
-If the current URL fails (returns non-200 status), it switches to the next URL.`,
+If the current URL fails (returns non-200 status), the request is retried right away
+against the next healthy URL. Each URL has a circuit breaker that opens after repeated
+failures, switching away from it until probe requests succeed again. Methods listed in
+--quorum-methods are sent to several URLs and only answered when enough of them agree.
+With --broadcast-fanout, transactions are broadcast to several URLs at once.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// 获取用户传入的参数
 		file, _ := cmd.Flags().GetString("file")
 		port, _ := cmd.Flags().GetInt("port")
-		limit, _ := cmd.Flags().GetInt("limit")
+		opts, err := proxyOptionsFromFlags(cmd)
+		if err != nil {
+			log.Fatalf("Invalid options: %v", err)
+		}
+		opts.Retries, _ = cmd.Flags().GetInt("retries")
+		opts.BroadcastFanout, _ = cmd.Flags().GetInt("broadcast-fanout")
+		opts.QuorumMethods, _ = cmd.Flags().GetStringSlice("quorum-methods")
+		opts.QuorumSize, _ = cmd.Flags().GetInt("quorum-size")
+		opts.QuorumAgree, _ = cmd.Flags().GetInt("quorum-agree")
+		if opts.QuorumAgree < 1 || opts.QuorumAgree > opts.QuorumSize {
+			log.Fatalf("Invalid options: --quorum-agree must be between 1 and --quorum-size")
+		}
+		var health HealthCheck
+		health.Interval, _ = cmd.Flags().GetDuration("health-interval")
+		health.Timeout, _ = cmd.Flags().GetDuration("health-timeout")
+		health.MaxLag, _ = cmd.Flags().GetInt64("max-lag")
+		health.MaxBlockAge, _ = cmd.Flags().GetDuration("max-block-age")
+		strategyName, _ := cmd.Flags().GetString("strategy")
+		strategy, err := newStrategy(strategyName)
+		if err != nil {
+			log.Fatalf("Invalid --strategy: %v", err)
+		}
 
-		// 从文件中读取 URL 列表
-		urls, err := readURLsFromFile(file)
+		// 从文件中读取 URL 列表，每行可以在 URL 后跟一个权重
+		lines, err := readURLsFromFile(file)
 		if err != nil {
 			log.Fatalf("Failed to read URLs from file: %v", err)
 		}
-		if len(urls) == 0 {
+		if len(lines) == 0 {
 			log.Fatalf("No URLs found in file: %s", file)
 		}
+		urls, weights, err := parseWeightedURLs(lines)
+		if err != nil {
+			log.Fatalf("Invalid URL file %s: %v", file, err)
+		}
+
+		// 上游池负责记录各节点状态，按策略分配请求，节点熔断或健康检查失败时切换 URL
+		pool := NewUpstreamPool(urls)
+		pool.Strategy = strategy
+		pool.Breaker.ConsecutiveFailures, _ = cmd.Flags().GetInt("breaker-failures")
+		pool.Breaker.ErrorRatio, _ = cmd.Flags().GetFloat64("breaker-error-ratio")
+		pool.Breaker.MinRequests, _ = cmd.Flags().GetInt("breaker-min-requests")
+		pool.Breaker.Window, _ = cmd.Flags().GetDuration("breaker-window")
+		pool.Breaker.Cooldown, _ = cmd.Flags().GetDuration("breaker-cooldown")
+		pool.Breaker.HalfOpenProbes, _ = cmd.Flags().GetInt("breaker-probes")
+		for i, u := range pool.Upstreams() {
+			u.Weight = weights[i]
+		}
 
 		// 启动代理服务器
-		proxyWithFallbackHandlerFunc(urls, port, limit)
+		proxyWithFallbackHandlerFunc(pool, port, opts, health)
 	},
 }
 
@@ -41,105 +83,59 @@ func init() {
 	rootCmd.AddCommand(proxysCmd)
 
 	// 定义命令行标志
-	proxysCmd.PersistentFlags().String("file", "urls", "file containing list of URLs")
+	proxysCmd.PersistentFlags().String("file", "urls", "file containing list of URLs, one per line, optionally followed by a weight")
+	proxysCmd.PersistentFlags().String("strategy", "failover", "load balancing strategy across healthy URLs: "+strategyNames())
 	proxysCmd.PersistentFlags().Int("port", 26657, "listen port")
-	proxysCmd.PersistentFlags().Int("limit", 10, "maximum requests per second")
+	addProxyFlags(proxysCmd)
+	proxysCmd.PersistentFlags().Int("retries", 2, "maximum retries against other upstreams per request")
+	proxysCmd.PersistentFlags().StringSlice("quorum-methods", nil, "cross-check these read-only RPC methods across URLs (trailing * matches a prefix); pin a height for stable results")
+	proxysCmd.PersistentFlags().Int("quorum-size", 3, "number of URLs a quorum read is sent to")
+	proxysCmd.PersistentFlags().Int("quorum-agree", 2, "number of identical responses a quorum read needs")
+	proxysCmd.PersistentFlags().Int("broadcast-fanout", 1, "send broadcast_tx_sync/async to up to this many healthy URLs in parallel and return the first accepted CheckTx, 1 disables it")
+	proxysCmd.PersistentFlags().Duration("health-interval", 10*time.Second, "interval between /status health checks of all URLs, 0 disables them")
+	proxysCmd.PersistentFlags().Duration("health-timeout", 5*time.Second, "timeout of a single /status health check")
+	proxysCmd.PersistentFlags().Int64("max-lag", 5, "mark a URL unhealthy when it lags the best height by more than this many blocks, 0 disables the check")
+	proxysCmd.PersistentFlags().Duration("max-block-age", time.Minute, "mark a URL unhealthy when its latest block is older than this, 0 disables the check")
+	breaker := defaultBreakerConfig()
+	proxysCmd.PersistentFlags().Int("breaker-failures", breaker.ConsecutiveFailures, "open a URL's circuit breaker after this many consecutive failures, 0 disables it")
+	proxysCmd.PersistentFlags().Float64("breaker-error-ratio", breaker.ErrorRatio, "open a URL's circuit breaker when its error ratio in --breaker-window reaches this, 0 disables it")
+	proxysCmd.PersistentFlags().Int("breaker-min-requests", breaker.MinRequests, "minimum requests in --breaker-window before the error ratio is considered")
+	proxysCmd.PersistentFlags().Duration("breaker-window", breaker.Window, "window for counting a URL's error ratio")
+	proxysCmd.PersistentFlags().Duration("breaker-cooldown", breaker.Cooldown, "time an open circuit breaker waits before letting probe requests through")
+	proxysCmd.PersistentFlags().Int("breaker-probes", breaker.HalfOpenProbes, "probe requests let through per cooldown while a circuit breaker is half-open")
 }
 
-func proxyWithFallbackHandlerFunc(urls []string, port int, limit int) {
-	// 创建令牌桶
-	tokens := make(chan struct{}, limit)
-	go func() {
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			// 每秒重新填充令牌桶
-			for i := 0; i < limit-len(tokens); i++ {
-				tokens <- struct{}{}
-			}
-		}
-	}()
-
-	// 使用一个变量记录当前的目标 URL 索引
-	currentURLIndex := 0
-	errorRecords := make([]time.Time, 0) // 存储错误记录的时间
+func proxyWithFallbackHandlerFunc(pool *UpstreamPool, port int, opts proxyOptions, health HealthCheck) {
+	ctx, stop := signalContext()
+	defer stop()
+	h := newProxyHandler(ctx, pool, opts)
+	if health.Interval > 0 {
+		h.background(func(ctx context.Context) { pool.HealthCheck(ctx, health) })
+	}
 
-	// 定期检查过去 20 秒内是否有错误，并切换 URL
-	go func() {
-		for range time.Tick(30 * time.Second) {
-			// 记录过去 20 秒内的错误
-			threshold := time.Now().Add(-20 * time.Second)
-			var recentErrors []time.Time
-			for _, record := range errorRecords {
-				if record.After(threshold) {
-					recentErrors = append(recentErrors, record)
-				}
-			}
+	serverAddr := fmt.Sprintf(":%d", port)
+	log.Printf("Proxy server with fallback listening on %s, limit: %d requests/sec per client...", serverAddr, opts.Limit)
+	serveProxy(ctx, serverAddr, h)
+}
 
-			// 如果有错误记录，则切换 URL
-			if len(recentErrors) > 0 {
-				log.Printf("Detected errors in the past 20 seconds, switching to the next URL.")
-				currentURLIndex = (currentURLIndex + 1) % len(urls)
-				errorRecords = []time.Time{} // 清空错误记录
+// parseWeightedURLs 解析 "URL [权重]" 格式的行，未写权重时为 1
+func parseWeightedURLs(lines []string) ([]string, []int, error) {
+	urls := make([]string, 0, len(lines))
+	weights := make([]int, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		weight := 1
+		if len(fields) > 1 {
+			w, err := strconv.Atoi(fields[1])
+			if err != nil || w <= 0 {
+				return nil, nil, fmt.Errorf("invalid weight %q for %s", fields[1], fields[0])
 			}
+			weight = w
 		}
-	}()
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// 从令牌桶中获取令牌（如果没有令牌，会阻塞直到有令牌可用）
-		<-tokens
-
-		targetURL := urls[currentURLIndex]
-
-		// 构建请求
-		req, err := http.NewRequest(r.Method, targetURL+r.URL.Path, r.Body)
-		if err != nil {
-			log.Printf("Error creating request for URL %s: %v", targetURL, err)
-			// 切换到下一个 URL
-			currentURLIndex = (currentURLIndex + 1) % len(urls)
-			return
-		}
-
-		// 设置请求头
-		req.Header = r.Header
-		req.Header.Set("Content-Type", "application/json")
-
-		// 创建 HTTP 客户端
-		client := &http.Client{}
-		start := time.Now()
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Error making proxy request to URL %s: %v", targetURL, err)
-			// 切换到下一个 URL
-			errorRecords = append(errorRecords, time.Now())
-			return
-		}
-		defer resp.Body.Close()
-
-		// 检查响应状态码
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Non-200 response from URL %s: %d", targetURL, resp.StatusCode)
-			// 记录错误时间
-			errorRecords = append(errorRecords, time.Now())
-			return
-		}
-
-		// 记录目标服务器的响应信息
-		log.Printf("Response from target: %s, status: %d, duration: %v", targetURL, resp.StatusCode, time.Since(start))
-
-		// 将响应写回客户端
-		w.WriteHeader(resp.StatusCode)
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			log.Printf("Error copying response body: %v", err)
-		}
-		return
-	})
-
-	serverAddr := fmt.Sprintf(":%d", port)
-	log.Printf("Proxy server with fallback listening on %s, limit: %d requests/sec...", serverAddr, limit)
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
-		log.Fatalf("ListenAndServe failed: %v", err)
+		urls = append(urls, fields[0])
+		weights = append(weights, weight)
 	}
+	return urls, weights, nil
 }
 
 func readURLsFromFile(file string) ([]string, error) {
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// quorumError 表示上游的响应没有达到法定一致数
+type quorumError struct {
+	agree, need int
+	groups      [][]string // 各组响应相同的上游
+	failed      []string   // 没有返回成功响应的上游
+}
+
+func (e *quorumError) Error() string {
+	var parts []string
+	for _, g := range e.groups {
+		parts = append(parts, "["+strings.Join(g, " ")+"]")
+	}
+	msg := fmt.Sprintf("%d of %d required upstreams agree, response groups: %s", e.agree, e.need, strings.Join(parts, " "))
+	if len(e.failed) > 0 {
+		msg += ", failed: " + strings.Join(e.failed, " ")
+	}
+	return msg
+}
+
+// quorumable 判断请求是否需要多个上游交叉验证：所有调用都是只读的，且都在 --quorum-methods 中
+func (h *proxyHandler) quorumable(rpc *RPCRequest) bool {
+	if len(h.opts.QuorumMethods) == 0 || len(rpc.Calls) == 0 {
+		return false
+	}
+	for _, call := range rpc.Calls {
+		if !isReadOnly(call.Method) || !matchMethod(h.opts.QuorumMethods, call.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalResponse 返回去掉 id 并按键名排序后的响应体，用于比较不同上游的结果
+func canonicalResponse(body []byte) (string, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", err
+	}
+	switch resp := v.(type) {
+	case map[string]any:
+		delete(resp, "id")
+	case []any:
+		for _, item := range resp {
+			if m, ok := item.(map[string]any); ok {
+				delete(m, "id")
+			}
+		}
+	}
+	// json.Marshal 会对 map 的键排序
+	out, err := json.Marshal(v)
+	return string(out), err
+}
+
+type quorumVote struct {
+	upstream *Upstream
+	resp     *upstreamResponse
+	err      error
+}
+
+// forwardQuorum 把请求同时发往 QuorumSize 个上游，比较规范化后的结果，
+// 有 QuorumAgree 个上游一致时返回该结果，其余请求随即取消；达不到时返回 *quorumError。
+// 响应不一致时记录各组上游，即使最终达到了法定一致数
+func (h *proxyHandler) forwardQuorum(r *http.Request, body []byte, methods string, rt route) (*upstreamResponse, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	qr := r.WithContext(ctx)
+	logger := requestLogger(r.Context())
+
+	var picked []*Upstream
+	for len(picked) < h.opts.QuorumSize {
+		upstream := h.pool.Pick(picked, rt)
+		if upstream == nil {
+			break
+		}
+		picked = append(picked, upstream)
+	}
+	if entry := accessEntryFrom(r.Context()); entry != nil {
+		entry.Attempts = len(picked)
+	}
+	if len(picked) < h.opts.QuorumAgree {
+		return nil, &quorumError{need: h.opts.QuorumAgree}
+	}
+
+	votes := make(chan quorumVote, len(picked))
+	for i, upstream := range picked {
+		go func(n int, upstream *Upstream) {
+			resp, err := h.attempt(qr, upstream, body, methods, n)
+			votes <- quorumVote{upstream: upstream, resp: resp, err: err}
+		}(i+1, upstream)
+	}
+
+	var order []string
+	groups := make(map[string][]*quorumVote)
+	var failed []string
+	for range picked {
+		vote := <-votes
+		if vote.err != nil || vote.resp.Status != http.StatusOK {
+			failed = append(failed, vote.upstream.URL)
+			continue
+		}
+		key, err := canonicalResponse(vote.resp.Body)
+		if err != nil {
+			failed = append(failed, vote.upstream.URL)
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], &vote)
+		if len(groups[key]) >= h.opts.QuorumAgree {
+			if len(order) > 1 {
+				logger.Warn("Quorum reached despite mismatching upstreams", "methods", methods, "groups", quorumGroups(order, groups))
+			}
+			metricQuorum.WithLabelValues("agreed").Inc()
+			return vote.resp, nil
+		}
+	}
+
+	qerr := &quorumError{need: h.opts.QuorumAgree, groups: quorumGroups(order, groups), failed: failed}
+	for _, key := range order {
+		if len(groups[key]) > qerr.agree {
+			qerr.agree = len(groups[key])
+		}
+	}
+	if len(order) > 1 {
+		metricQuorum.WithLabelValues("mismatch").Inc()
+		logger.Warn("Upstream responses disagree", "methods", methods, "groups", qerr.groups, "failed", failed)
+	} else {
+		metricQuorum.WithLabelValues("insufficient").Inc()
+	}
+	return nil, qerr
+}
+
+// quorumGroups 按首次出现的顺序列出每组响应相同的上游
+func quorumGroups(order []string, groups map[string][]*quorumVote) [][]string {
+	out := make([][]string, 0, len(order))
+	for _, key := range order {
+		var urls []string
+		for _, vote := range groups[key] {
+			urls = append(urls, vote.upstream.URL)
+		}
+		out = append(out, urls)
+	}
+	return out
+}
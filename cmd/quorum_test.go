@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalResponse(t *testing.T) {
+	a, err := canonicalResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":{"b":1,"a":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := canonicalResponse([]byte(`{"result":{"a":2,"b":1},"id":"x","jsonrpc":"2.0"}`))
+	if a != b {
+		t.Errorf("canonicalResponse() = %s and %s, want the same", a, b)
+	}
+}
+
+// TestQuorum 检查法定一致的结果被返回，达不到时返回错误，不在 --quorum-methods 中的调用只发往一个上游
+func TestQuorum(t *testing.T) {
+	withHash := func(hash string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"hash":%q}}`, hash)
+		}
+	}
+	quorum := func(opts *proxyOptions) {
+		opts.QuorumMethods = []string{"block*"}
+		opts.QuorumSize = 3
+		opts.QuorumAgree = 2
+	}
+
+	upstreams := []*testUpstream{newTestUpstream(t, withHash("AA")), newTestUpstream(t, withHash("BB")), newTestUpstream(t, withHash("AA"))}
+	h := newTestHandler(t, upstreams, quorum)
+	w := serve(h, http.MethodGet, "/block?height=5", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"AA"`) {
+		t.Errorf("status = %d, want the agreed result: %s", w.Code, w.Body)
+	}
+	for i, u := range upstreams {
+		if len(u.received()) != 1 {
+			t.Errorf("upstream %d received %q, want the quorum read", i, u.received())
+		}
+	}
+	serve(h, http.MethodGet, "/status", "")
+	total := 0
+	for _, u := range upstreams {
+		total += len(u.received())
+	}
+	if total != 4 {
+		t.Errorf("upstreams received %d requests, want status sent to only one", total)
+	}
+
+	upstreams = []*testUpstream{newTestUpstream(t, withHash("AA")), newTestUpstream(t, withHash("BB")), newTestUpstream(t, withHash("CC"))}
+	h = newTestHandler(t, upstreams, quorum)
+	w = serve(h, http.MethodGet, "/block?height=5", "")
+	if w.Code != http.StatusBadGateway || rpcError(t, w.Body.Bytes()).Code != codeQuorumFailed {
+		t.Errorf("status = %d, want quorum failure: %s", w.Code, w.Body)
+	}
+}
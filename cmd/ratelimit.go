@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// bucketIdleTTL 是空闲令牌桶被清理前的保留时间
+const bucketIdleTTL = 5 * time.Minute
+
+// tokenBucket 是一个按时间连续补充的令牌桶，令牌数可以为负，表示已被排队的请求预支
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait 返回获取 n 个令牌还需要等待的时间
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// BucketConfig 是一类令牌桶的速率和容量
+type BucketConfig struct {
+	Rate  float64 // 每秒补充的令牌数，0 表示不限制
+	Burst float64 // 桶容量
+}
+
+// RateLimiter 为每个客户端维护独立的令牌桶。
+// 令牌不足时，MaxWait 为 0 则立即拒绝；否则在排队数未超过 QueueSize 且
+// 需要等待的时间不超过 MaxWait 时预支令牌并等待
+type RateLimiter struct {
+	MaxWait   time.Duration
+	QueueSize int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	waiting int
+}
+
+// NewRateLimiter 创建限流器，并启动清理空闲令牌桶的协程
+func NewRateLimiter(ctx context.Context, maxWait time.Duration, queueSize int) *RateLimiter {
+	l := &RateLimiter{
+		MaxWait:   maxWait,
+		QueueSize: queueSize,
+		buckets:   make(map[string]*tokenBucket),
+	}
+	go l.cleanup(ctx)
+	return l
+}
+
+// LimitKey 是一个需要扣减令牌的桶
+type LimitKey struct {
+	Key    string
+	Config BucketConfig
+}
+
+// Allow 从所有给定的桶中扣减 n 个令牌，只有全部满足时才会扣减。n 超过桶容量的请求
+// 永远无法满足，直接拒绝并返回 0，调用方应先用 Exceeds 检查。
+// 允许时返回 true（可能已经排队等待过）；拒绝时返回建议的重试等待时间
+func (l *RateLimiter) Allow(ctx context.Context, keys []LimitKey, n float64) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	var buckets []*tokenBucket
+	var needs []float64
+	var wait time.Duration
+	for _, k := range keys {
+		if k.Config.Rate <= 0 {
+			continue
+		}
+		b := l.buckets[k.Key]
+		if b == nil {
+			b = &tokenBucket{tokens: k.Config.Burst, last: now}
+			l.buckets[k.Key] = b
+		}
+		// 配置可能在运行时变化，例如 API key 换了等级
+		b.rate, b.burst = k.Config.Rate, k.Config.Burst
+		if n > b.burst {
+			l.mu.Unlock()
+			return 0, false
+		}
+		b.refill(now)
+		if d := b.wait(n); d > wait {
+			wait = d
+		}
+		buckets = append(buckets, b)
+		needs = append(needs, n)
+	}
+
+	if wait > 0 && (wait > l.MaxWait || l.waiting >= l.QueueSize) {
+		l.mu.Unlock()
+		return wait, false
+	}
+	for i, b := range buckets {
+		b.tokens -= needs[i]
+	}
+	if wait == 0 {
+		l.mu.Unlock()
+		return 0, true
+	}
+	l.waiting++
+	l.mu.Unlock()
+
+	// 排队等待预支的令牌补充完成
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, true
+	case <-ctx.Done():
+		// 客户端放弃了请求，退还预支的令牌
+		l.mu.Lock()
+		for i, b := range buckets {
+			b.tokens = math.Min(b.burst, b.tokens+needs[i])
+		}
+		l.mu.Unlock()
+		return wait, false
+	}
+}
+
+// Exceeds 返回第一个容量小于 n 的桶配置，这样的请求无论等待多久都不会被允许
+func Exceeds(keys []LimitKey, n float64) (BucketConfig, bool) {
+	for _, k := range keys {
+		if k.Config.Rate > 0 && n > k.Config.Burst {
+			return k.Config, true
+		}
+	}
+	return BucketConfig{}, false
+}
+
+// cleanup 定期清理已经补满且长时间未使用的令牌桶，ctx 结束时退出
+func (l *RateLimiter) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		n          float64
+		wantTokens float64
+		wantWait   time.Duration
+	}{
+		{"full bucket", 10, 0, 4, 10, 0},
+		{"refill is capped at burst", 8, time.Minute, 10, 10, 0},
+		{"partial refill", 0, 200 * time.Millisecond, 2, 2, 0},
+		{"short of tokens", 1, 0, 3, 1, 200 * time.Millisecond},
+		{"negative after queued requests", -5, 0, 5, -5, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			b := &tokenBucket{rate: 10, burst: 10, tokens: tt.tokens, last: start}
+			b.refill(start.Add(tt.elapsed))
+			if b.tokens != tt.wantTokens {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.wantTokens)
+			}
+			if got := b.wait(tt.n); got != tt.wantWait {
+				t.Errorf("wait(%v) = %v, want %v", tt.n, got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func newTestLimiter(maxWait time.Duration, queueSize int) *RateLimiter {
+	return &RateLimiter{MaxWait: maxWait, QueueSize: queueSize, buckets: make(map[string]*tokenBucket)}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	small := BucketConfig{Rate: 1, Burst: 2}
+	large := BucketConfig{Rate: 100, Burst: 100}
+	tests := []struct {
+		name      string
+		maxWait   time.Duration
+		keys      []LimitKey
+		costs     []float64 // 依次请求，只检查最后一个
+		wantOK    bool
+		wantRetry bool
+	}{
+		{"within burst", 0, []LimitKey{{"ip", small}}, []float64{1, 1}, true, false},
+		{"over burst rejected with retry", 0, []LimitKey{{"ip", small}}, []float64{2, 1}, false, true},
+		{"cost above burst never allowed", time.Minute, []LimitKey{{"ip", small}}, []float64{3}, false, false},
+		{"unlimited bucket", 0, []LimitKey{{"ip", BucketConfig{}}}, []float64{1000, 1000}, true, false},
+		{"every bucket must allow", 0, []LimitKey{{"ip", large}, {"key", small}}, []float64{2, 1}, false, true},
+		{"queued until refilled", 100 * time.Millisecond, []LimitKey{{"ip", large}}, []float64{100, 1}, true, false},
+		{"wait longer than max wait", time.Millisecond, []LimitKey{{"ip", small}}, []float64{2, 1}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(tt.maxWait, 10)
+			var retry time.Duration
+			var ok bool
+			for _, n := range tt.costs {
+				retry, ok = l.Allow(context.Background(), tt.keys, n)
+			}
+			if ok != tt.wantOK || (retry > 0) != tt.wantRetry {
+				t.Fatalf("Allow() = %v, %v; want ok %v, retry %v", retry, ok, tt.wantOK, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllOrNothing(t *testing.T) {
+	l := newTestLimiter(0, 10)
+	keys := []LimitKey{{"ip", BucketConfig{Rate: 1, Burst: 10}}, {"key", BucketConfig{Rate: 1, Burst: 2}}}
+	if _, ok := l.Allow(context.Background(), keys, 2); !ok {
+		t.Fatal("first request rejected")
+	}
+	if _, ok := l.Allow(context.Background(), keys, 2); ok {
+		t.Fatal("second request allowed")
+	}
+	// 被拒绝的请求不扣减任何桶
+	if got := l.buckets["ip"].tokens; got < 7.9 || got > 8.1 {
+		t.Fatalf("ip bucket has %v tokens, want 8", got)
+	}
+}
+
+func TestRateLimiterRefundsOnCancel(t *testing.T) {
+	l := newTestLimiter(time.Minute, 10)
+	keys := []LimitKey{{"ip", BucketConfig{Rate: 1, Burst: 1}}}
+	if _, ok := l.Allow(context.Background(), keys, 1); !ok {
+		t.Fatal("first request rejected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := l.Allow(ctx, keys, 1); ok {
+		t.Fatal("queued request allowed after its context ended")
+	}
+	l.mu.Lock()
+	tokens, waiting := l.buckets["ip"].tokens, l.waiting
+	l.mu.Unlock()
+	if tokens < -0.1 || waiting != 0 {
+		t.Fatalf("after cancel: tokens = %v, waiting = %d; want the tokens refunded and no waiters", tokens, waiting)
+	}
+}
+
+func TestExceeds(t *testing.T) {
+	keys := []LimitKey{{"ip", BucketConfig{Rate: 10, Burst: 20}}, {"key", BucketConfig{Rate: 5, Burst: 5}}, {"free", BucketConfig{}}}
+	if _, ok := Exceeds(keys, 5); ok {
+		t.Error("cost 5 exceeds a burst of 5")
+	}
+	if cfg, ok := Exceeds(keys, 6); !ok || cfg.Burst != 5 {
+		t.Errorf("Exceeds(6) = %v, %v; want the key bucket", cfg, ok)
+	}
+}
@@ -6,6 +6,9 @@ package cmd
 
 import (
 	"os"
+	"tedtool/cmd/cache"
+	"tedtool/cmd/certs"
+	"tedtool/cmd/keys"
 	"tedtool/cmd/peer"
 
 	"github.com/spf13/cobra"
@@ -24,6 +27,11 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		level, _ := cmd.Flags().GetString("log-level")
+		format, _ := cmd.Flags().GetString("log-format")
+		return setupLogging(level, format)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -41,10 +49,15 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.tedtool.yaml)")
+	rootCmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "log format: text or json")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	rootCmd.AddCommand(peer.PeerCmd)
+	rootCmd.AddCommand(keys.KeysCmd)
+	rootCmd.AddCommand(cache.CacheCmd)
+	rootCmd.AddCommand(certs.CertsCmd)
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals 是触发优雅退出的信号
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// signalContext 返回收到退出信号时结束的 context
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), shutdownSignals...)
+}
+
+// serveProxy 在 addr 上运行代理，直到 ctx 结束。之后停止接受新连接，同时向 WebSocket 客户端
+// 发送关闭帧，在 opts.ShutdownTimeout 内等待处理中的请求完成，最后停止后台任务。
+// 等待期间再次收到信号会直接退出
+func serveProxy(ctx context.Context, addr string, h *proxyHandler) {
+	srv := &http.Server{Addr: addr, Handler: h}
+
+	errc := make(chan error, 1)
+	if h.opts.TLSCerts != nil {
+		srv.TLSConfig = serverTLSConfig(h.opts.TLSCerts, h.opts.TLSClientCAs)
+		h.background(func(ctx context.Context) { h.opts.TLSCerts.Watch(ctx, 10*time.Second) })
+		log.Printf("TLS enabled, client certificates required: %v", h.opts.TLSClientCAs != nil)
+		go func() {
+			errc <- srv.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			errc <- srv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		log.Fatalf("ListenAndServe failed: %v", err)
+	case <-ctx.Done():
+	}
+	signal.Reset(shutdownSignals...)
+
+	log.Printf("Shutting down, waiting up to %v for in-flight requests...", h.opts.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), h.opts.ShutdownTimeout)
+	defer cancel()
+	// Shutdown 不会等待已被接管的 WebSocket 连接，需要单独关闭，与 Shutdown 同时进行。
+	// RegisterOnShutdown 的回调没有人等待，进程可能在关闭帧发出前退出，所以这里等它完成
+	wsDone := make(chan struct{})
+	go func() {
+		defer close(wsDone)
+		h.ws.shutdown(shutdownCtx)
+	}()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown deadline exceeded, closing remaining connections: %v", err)
+		srv.Close()
+	}
+	<-wsDone
+	// 后台任务在请求处理完之后才停止，用量在所有请求记录之后写入
+	h.stop()
+	log.Printf("Proxy server stopped")
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestServeProxyShutdown 检查退出时先等处理中的请求完成、关闭 WebSocket 客户端，再停止后台任务
+func TestServeProxyShutdown(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"jsonrpc":"2.0","id":-1,"result":{}}`))
+	})
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.ShutdownTimeout = 5 * time.Second
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		serveProxy(ctx, addr, h)
+	}()
+
+	var ws *websocket.Conn
+	for i := 0; ws == nil; i++ {
+		if ws, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/websocket", nil); err != nil {
+			if i == 50 {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	defer ws.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/status")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	for len(upstream.received()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("WebSocket ReadMessage() error = %v, want close %d", err, websocket.CloseGoingAway)
+	}
+	select {
+	case <-h.ctx.Done():
+		t.Fatal("background tasks stopped before in-flight requests finished")
+	case <-stopped:
+		t.Fatal("serveProxy returned before in-flight requests finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", code)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveProxy did not return")
+	}
+	if h.ctx.Err() == nil {
+		t.Error("background tasks not stopped after shutdown")
+	}
+	if _, err := http.Get("http://" + addr + "/status"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("request after shutdown error = %v, want connection refused", err)
+	}
+}
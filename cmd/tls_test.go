@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tedtool/cmd/certs"
+)
+
+// newTestPKI 在临时目录中生成 CA、服务端证书和客户端证书
+func newTestPKI(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := certs.GenerateCA(dir, "test-ca", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.Issue(dir, "server", []string{"127.0.0.1"}, false, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.Issue(dir, "client", nil, true, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestMutualTLS 检查配置了客户端 CA 时只接受持有该 CA 签发的证书的客户端
+func TestMutualTLS(t *testing.T) {
+	dir := newTestPKI(t)
+	reloader, err := newCertReloader(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs, err := loadCertPool(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	// 不用 StartTLS，它会填入 httptest 自带的证书，优先于 GetCertificate
+	srv.Listener = tls.NewListener(srv.Listener, serverTLSConfig(reloader, clientCAs))
+	srv.Start()
+	defer srv.Close()
+	url := "https://" + srv.Listener.Addr().String()
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: clientCAs, Certificates: certs}}}
+	}
+
+	resp, err := client(clientCert).Get(url)
+	if err != nil {
+		t.Fatalf("request with client certificate: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := client().Get(url); err == nil {
+		t.Error("request without client certificate accepted")
+	}
+}
+
+// TestCertReload 检查证书文件更新后新的握手使用新证书
+func TestCertReload(t *testing.T) {
+	dir := newTestPKI(t)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Fatalf("Reload() = %v, %v without changes", reloaded, err)
+	}
+
+	if err := certs.Issue(dir, "server", []string{"localhost"}, false, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() = %v, %v after the files changed", reloaded, err)
+	}
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "localhost" {
+		t.Errorf("certificate SANs = %v, want the reissued certificate", leaf.DNSNames)
+	}
+
+	// 新证书无效时保留旧证书
+	os.WriteFile(certFile, []byte("broken"), 0o644)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("Reload() accepted an invalid certificate")
+	}
+	if current, _ := reloader.GetCertificate(nil); current != cert {
+		t.Error("invalid certificate replaced the current one")
+	}
+}
@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaAlpha 是延迟 EWMA 的平滑系数
+const ewmaAlpha = 0.2
+
+// Upstream 记录单个上游节点的运行状态，所有方法都可以并发调用
+type Upstream struct {
+	URL string
+	// Weight 是加权轮询时的权重
+	Weight int
+
+	outstanding atomic.Int64
+
+	mu          sync.Mutex
+	breaker     breaker
+	checkErr    error // 最近一次主动健康检查发现的问题
+	latencyEWMA time.Duration
+	height      int64
+	earliest    int64 // 上游保留的最早区块高度，0 表示未知
+	lastSeen    time.Time
+}
+
+// Healthy 返回上游当前是否健康，熔断中或主动健康检查未通过都视为不健康
+func (u *Upstream) Healthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthyLocked()
+}
+
+func (u *Upstream) healthyLocked() bool {
+	return u.breaker.state != BreakerOpen && u.checkErr == nil
+}
+
+// BreakerState 返回上游熔断器的当前状态
+func (u *Upstream) BreakerState() BreakerState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.breaker.state
+}
+
+// LatencyEWMA 返回上游响应延迟的指数加权移动平均值
+func (u *Upstream) LatencyEWMA() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.latencyEWMA
+}
+
+// Height 返回上游最后一次上报的区块高度及上报时间
+func (u *Upstream) Height() (int64, time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.height, u.lastSeen
+}
+
+// EarliestHeight 返回上游保留的最早区块高度，0 表示未知
+func (u *Upstream) EarliestHeight() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.earliest
+}
+
+// SetHeights 更新上游最后一次上报的最新和最早区块高度
+func (u *Upstream) SetHeights(latest, earliest int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.height = latest
+	u.earliest = earliest
+	u.lastSeen = time.Now()
+	metricUpstreamHeight.WithLabelValues(u.URL).Set(float64(latest))
+}
+
+// observeHeight 根据上游返回的结果提高它的最新高度，比已知高度低时不变
+func (u *Upstream) observeHeight(height int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if height <= u.height {
+		return
+	}
+	u.height = height
+	u.lastSeen = time.Now()
+	metricUpstreamHeight.WithLabelValues(u.URL).Set(float64(height))
+}
+
+// ObserveLatency 只更新延迟 EWMA，不影响熔断器，用于健康检查测得的延迟
+func (u *Upstream) ObserveLatency(latency time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.observeLatencyLocked(latency)
+}
+
+func (u *Upstream) observeLatencyLocked(latency time.Duration) {
+	if u.latencyEWMA == 0 {
+		u.latencyEWMA = latency
+	} else {
+		u.latencyEWMA = time.Duration(ewmaAlpha*float64(latency) + (1-ewmaAlpha)*float64(u.latencyEWMA))
+	}
+	metricUpstreamLatency.WithLabelValues(u.URL).Set(u.latencyEWMA.Seconds())
+}
+
+// Outstanding 返回发往上游、尚未完成的请求数
+func (u *Upstream) Outstanding() int64 {
+	return u.outstanding.Load()
+}
+
+// ready 判断上游能否被选中，不占用熔断器的探测名额
+func (u *Upstream) ready(cfg BreakerConfig, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.checkErr == nil && u.breaker.ready(cfg, now)
+}
+
+// UpstreamPool 管理一组上游节点，每个节点有独立的熔断器，当前节点熔断时切换到下一个节点
+type UpstreamPool struct {
+	// Breaker 是所有上游共用的熔断器配置，需在开始转发前设置
+	Breaker BreakerConfig
+	// Strategy 是在可用上游之间分配请求的策略，默认只使用当前上游
+	Strategy Strategy
+
+	// served 是已经返回给客户端的最新状态中的最高高度
+	served atomic.Int64
+
+	mu        sync.RWMutex
+	upstreams []*Upstream
+	current   int
+}
+
+// NewUpstreamPool 根据 URL 列表创建上游池，所有节点初始为健康状态
+func NewUpstreamPool(urls []string) *UpstreamPool {
+	p := &UpstreamPool{Breaker: defaultBreakerConfig(), Strategy: failoverStrategy{}}
+	for _, url := range urls {
+		p.upstreams = append(p.upstreams, &Upstream{URL: url, Weight: 1})
+		metricUpstreamHealthy.WithLabelValues(url).Set(1)
+		metricBreakerState.WithLabelValues(url).Set(float64(BreakerClosed))
+	}
+	return p
+}
+
+// Upstreams 返回池中所有上游
+func (p *UpstreamPool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Current 返回当前选中的上游
+func (p *UpstreamPool) Current() *Upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.upstreams[p.current]
+}
+
+// ReportSuccess 记录一次成功的请求并更新延迟 EWMA，latency 为 0 时不更新延迟
+func (p *UpstreamPool) ReportSuccess(u *Upstream, latency time.Duration) {
+	u.mu.Lock()
+	from := u.breaker.state
+	changed := u.breaker.success(p.Breaker, time.Now())
+	if latency > 0 {
+		u.observeLatencyLocked(latency)
+	}
+	u.mu.Unlock()
+
+	if changed {
+		p.breakerChanged(u, from, BreakerClosed)
+	}
+}
+
+// ReportFailure 记录一次失败的请求，熔断器因此打开且 u 是当前上游时切换到下一个上游
+func (p *UpstreamPool) ReportFailure(u *Upstream) {
+	u.mu.Lock()
+	from := u.breaker.state
+	changed := u.breaker.failure(p.Breaker, time.Now())
+	u.mu.Unlock()
+	metricUpstreamFailures.WithLabelValues(u.URL).Inc()
+
+	if changed {
+		p.breakerChanged(u, from, BreakerOpen)
+		if p.Current() == u {
+			p.SwitchFrom(u)
+		}
+	}
+}
+
+// acquire 判断是否可以选择 u：健康检查未通过或熔断中时不可选，
+// 半开状态下占用一个探测名额
+func (p *UpstreamPool) acquire(u *Upstream) bool {
+	u.mu.Lock()
+	if u.checkErr != nil {
+		u.mu.Unlock()
+		return false
+	}
+	from := u.breaker.state
+	ok := u.breaker.allow(p.Breaker, time.Now())
+	to := u.breaker.state
+	u.mu.Unlock()
+
+	if from != to {
+		p.breakerChanged(u, from, to)
+	}
+	return ok
+}
+
+// breakerChanged 输出并导出熔断器的状态变化
+func (p *UpstreamPool) breakerChanged(u *Upstream, from, to BreakerState) {
+	log.Printf("Circuit breaker for %s: %s -> %s", u.URL, from, to)
+	metricBreakerState.WithLabelValues(u.URL).Set(float64(to))
+	metricBreakerTransitions.WithLabelValues(u.URL, from.String(), to.String()).Inc()
+	metricUpstreamHealthy.WithLabelValues(u.URL).Set(boolToFloat(u.Healthy()))
+}
+
+// SwitchFrom 在 u 仍是当前上游时切换到下一个上游，多个请求同时失败时只会切换一次
+func (p *UpstreamPool) SwitchFrom(u *Upstream) *Upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.upstreams[p.current] != u {
+		return p.upstreams[p.current]
+	}
+
+	// 优先选择健康的节点，全部不健康时按顺序轮换
+	next := (p.current + 1) % len(p.upstreams)
+	for i := 1; i < len(p.upstreams); i++ {
+		idx := (p.current + i) % len(p.upstreams)
+		if p.upstreams[idx].Healthy() {
+			next = idx
+			break
+		}
+	}
+	p.current = next
+	metricUpstreamSwitches.WithLabelValues(u.URL, p.upstreams[next].URL).Inc()
+	log.Printf("Switching upstream from %s to %s", u.URL, p.upstreams[next].URL)
+	return p.upstreams[next]
+}
+
+// Pick 按策略从未尝试过、满足高度要求且可用的上游中选择一个，半开的上游会作为探测被选中；
+// 若这些上游都不可用，则返回其中从当前上游开始的第一个，全部尝试过时返回 nil
+func (p *UpstreamPool) Pick(tried []*Upstream, rt route) *Upstream {
+	p.mu.RLock()
+	start := p.current
+	p.mu.RUnlock()
+
+	archive := p.archiveHeight()
+	now := time.Now()
+	var candidates []*Upstream
+	var fallback *Upstream
+	for i := 0; i < len(p.upstreams); i++ {
+		u := p.upstreams[(start+i)%len(p.upstreams)]
+		if containsUpstream(tried, u) || !canServe(u, rt, archive) {
+			continue
+		}
+		if fallback == nil {
+			fallback = u
+		}
+		if u.ready(p.Breaker, now) {
+			candidates = append(candidates, u)
+		}
+	}
+
+	// 探测名额可能已被并发的请求占用，此时换一个候选
+	for len(candidates) > 0 {
+		u := p.Strategy.Choose(candidates)
+		if p.acquire(u) {
+			return u
+		}
+		candidates = removeUpstream(candidates, u)
+	}
+	return fallback
+}
+
+// archiveHeight 返回池内已知的最早区块高度，都未知时返回 0
+func (p *UpstreamPool) archiveHeight() int64 {
+	var archive int64
+	for _, u := range p.upstreams {
+		if earliest := u.EarliestHeight(); earliest > 0 && (archive == 0 || earliest < archive) {
+			archive = earliest
+		}
+	}
+	return archive
+}
+
+// BestHeight 返回池内上游上报的最高高度，都未知时返回 0
+func (p *UpstreamPool) BestHeight() int64 {
+	var best int64
+	for _, u := range p.upstreams {
+		if latest, _ := u.Height(); latest > best {
+			best = latest
+		}
+	}
+	return best
+}
+
+// ServedHeight 返回已经返回给客户端的最新状态中的最高高度，还没有返回过时为 0
+func (p *UpstreamPool) ServedHeight() int64 {
+	return p.served.Load()
+}
+
+// RecordServed 记录 u 返回给客户端的最新高度，同时据此更新 u 的高度
+func (p *UpstreamPool) RecordServed(u *Upstream, height int64) {
+	u.observeHeight(height)
+	for {
+		served := p.served.Load()
+		if height <= served || p.served.CompareAndSwap(served, height) {
+			return
+		}
+	}
+}
+
+// eligible 返回满足高度要求的上游个数
+func (p *UpstreamPool) eligible(rt route) int {
+	archive := p.archiveHeight()
+	n := 0
+	for _, u := range p.upstreams {
+		if canServe(u, rt, archive) {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckHeight 判断是否有上游保留了 need 高度的区块，没有时返回错误
+func (p *UpstreamPool) CheckHeight(need int64) error {
+	if p.eligible(route{Need: need}) > 0 {
+		return nil
+	}
+	return fmt.Errorf("height %d is not available on any upstream, earliest available height is %d", need, p.archiveHeight())
+}
+
+func removeUpstream(list []*Upstream, u *Upstream) []*Upstream {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != u {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsUpstream(list []*Upstream, u *Upstream) bool {
+	for _, v := range list {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestUpstreamPoolConcurrent 在并发的请求中选择上游、上报结果和切换当前上游，用 -race 运行
+func TestUpstreamPoolConcurrent(t *testing.T) {
+	p := NewUpstreamPool([]string{"http://a", "http://b", "http://c"})
+	p.Breaker.ConsecutiveFailures = 2
+	p.Breaker.Cooldown = time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				var tried []*Upstream
+				for u := p.Pick(tried, route{}); u != nil; u = p.Pick(tried, route{}) {
+					tried = append(tried, u)
+					switch (i + j + len(tried)) % 3 {
+					case 0:
+						p.ReportFailure(u)
+					case 1:
+						p.ReportSuccess(u, time.Millisecond)
+					default:
+						p.SwitchFrom(u)
+					}
+				}
+				// 没有高度要求时每个上游都会被尝试一次
+				if len(tried) != len(p.Upstreams()) {
+					t.Errorf("tried %d upstreams, want %d", len(tried), len(p.Upstreams()))
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	current := p.Current()
+	if !containsUpstream(p.Upstreams(), current) {
+		t.Fatalf("current upstream %v is not in the pool", current)
+	}
+}
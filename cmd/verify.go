@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tedtool/cmd/lightclient"
+
+	cmtmath "github.com/cometbft/cometbft/libs/math"
+	"github.com/cometbft/cometbft/light/provider"
+	"github.com/spf13/pflag"
+)
+
+// verifyConfigFromFlags 读取轻客户端验证的标志，未开启验证时返回 nil
+func verifyConfigFromFlags(flags *pflag.FlagSet) (*lightclient.Config, error) {
+	if enabled, _ := flags.GetBool("verify"); !enabled {
+		return nil, nil
+	}
+	cfg := lightclient.DefaultConfig()
+	cfg.ChainID, _ = flags.GetString("chain-id")
+	cfg.TrustedHeight, _ = flags.GetInt64("trusted-height")
+	cfg.TrustingPeriod, _ = flags.GetDuration("trusting-period")
+	trustedHash, _ := flags.GetString("trusted-hash")
+	trustLevel, _ := flags.GetString("trust-level")
+	mode, _ := flags.GetString("verify-mode")
+
+	if cfg.ChainID == "" || cfg.TrustedHeight <= 0 || trustedHash == "" {
+		return nil, fmt.Errorf("--verify requires --chain-id, --trusted-height and --trusted-hash")
+	}
+	var err error
+	if cfg.TrustedHash, err = hex.DecodeString(strings.TrimPrefix(trustedHash, "0x")); err != nil {
+		return nil, fmt.Errorf("invalid --trusted-hash: %v", err)
+	}
+	if cfg.TrustLevel, err = cmtmath.ParseFraction(trustLevel); err != nil {
+		return nil, fmt.Errorf("invalid --trust-level: %v", err)
+	}
+	switch mode {
+	case "skipping":
+	case "sequential":
+		cfg.Sequential = true
+	default:
+		return nil, fmt.Errorf("invalid --verify-mode %q, must be skipping or sequential", mode)
+	}
+	return &cfg, nil
+}
+
+// newVerifier 创建轻客户端，每个上游是一个数据源，第一个上游作为 primary，其余作为 witness。
+// primary 作为自己的 witness 时交叉检查没有意义，所以至少需要两个上游
+func newVerifier(ctx context.Context, pool *UpstreamPool, cfg lightclient.Config, timeout time.Duration) (*lightclient.Verifier, error) {
+	upstreams := pool.Upstreams()
+	if len(upstreams) < 2 {
+		return nil, fmt.Errorf("--verify requires at least two upstreams to cross-check headers, got %d", len(upstreams))
+	}
+	client := &http.Client{Timeout: timeout}
+	var providers []provider.Provider
+	for _, u := range upstreams {
+		providers = append(providers, lightclient.NewHTTPProvider(cfg.ChainID, client, u.URL))
+	}
+	return lightclient.New(ctx, cfg, providers[0], providers[1:])
+}
+
+// verifyResponse 用轻客户端验证响应中每个需要验证的调用结果，错误对象不含数据，不需要验证。
+// 无法与请求中的调用对应的响应一律拒绝，source 是返回响应的上游地址
+func (h *proxyHandler) verifyResponse(ctx context.Context, source string, rpc *RPCRequest, body []byte) error {
+	if len(rpc.Calls) == 0 {
+		return fmt.Errorf("request has no calls to verify")
+	}
+	responses, err := decodeRPCResponses(body)
+	if err != nil {
+		return err
+	}
+
+	// 同一个 id 对应多个调用时无法确定响应属于哪个调用
+	calls := make(map[string]RPCCall, len(rpc.Calls))
+	for _, call := range rpc.Calls {
+		if len(call.ID) == 0 {
+			continue
+		}
+		if _, ok := calls[string(call.ID)]; ok {
+			return fmt.Errorf("duplicate id %s in batch", call.ID)
+		}
+		calls[string(call.ID)] = call
+	}
+	for _, resp := range responses {
+		call, ok := calls[string(resp.ID)]
+		if !ok && !rpc.Batch {
+			call, ok = rpc.Calls[0], true
+		}
+		if !ok {
+			return fmt.Errorf("response id %s does not match any call", resp.ID)
+		}
+		if resp.Error != nil {
+			continue
+		}
+		if err := h.verifyResult(ctx, source, call, resp.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyResult 验证单个调用的结果，结果必须对应调用参数中的高度和键，
+// 所以需要验证的方法只接受命名参数
+func (h *proxyHandler) verifyResult(ctx context.Context, source string, call RPCCall, result json.RawMessage) error {
+	params, named := call.NamedParams()
+	if !named {
+		if lightclient.Verifies(call.Method) {
+			return fmt.Errorf("%s: positional params can't be checked against the result, use named params", call.Method)
+		}
+		return nil
+	}
+	if !lightclient.NeedsVerification(call.Method, params) {
+		return nil
+	}
+	if err := h.verifier.Verify(ctx, source, call.Method, params, result); err != nil {
+		metricVerifications.WithLabelValues(call.Method, "failed").Inc()
+		return fmt.Errorf("%s: %v", call.Method, err)
+	}
+	metricVerifications.WithLabelValues(call.Method, "verified").Inc()
+	return nil
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"tedtool/cmd/cache"
+	"tedtool/cmd/lightclient"
+)
+
+// TestVerifyResponseRejectsUnverifiable 检查无法与请求中的调用对应的响应不会跳过验证。
+// 这些检查在调用轻客户端之前完成，零值的 Verifier 对任何需要验证的结果都会报错
+func TestVerifyResponseRejectsUnverifiable(t *testing.T) {
+	h := &proxyHandler{verifier: &lightclient.Verifier{}}
+	call := func(id, method, params string) RPCCall {
+		return RPCCall{ID: json.RawMessage(id), Method: method, Params: json.RawMessage(params)}
+	}
+	tests := []struct {
+		name    string
+		rpc     *RPCRequest
+		body    string
+		wantErr bool
+	}{
+		{"no calls", &RPCRequest{}, `<html></html>`, true},
+		{"not json-rpc", &RPCRequest{Calls: []RPCCall{call("1", "status", `{}`)}}, `<html>502</html>`, true},
+		{"unmatched batch id", &RPCRequest{Batch: true, Calls: []RPCCall{call("1", "block", `{"height":"5"}`)}},
+			`[{"jsonrpc":"2.0","id":2,"result":{}}]`, true},
+		{"duplicate batch id", &RPCRequest{Batch: true, Calls: []RPCCall{call("1", "block", `{"height":"5"}`), call("1", "health", `{}`)}},
+			`[{"jsonrpc":"2.0","id":1,"result":{}}]`, true},
+		{"positional params", &RPCRequest{Calls: []RPCCall{call("1", "block", `["5"]`)}},
+			`{"jsonrpc":"2.0","id":1,"result":{}}`, true},
+		{"unverified result", &RPCRequest{Calls: []RPCCall{call("1", "block", `{"height":"5"}`)}},
+			`{"jsonrpc":"2.0","id":1,"result":{}}`, true},
+		{"error object", &RPCRequest{Calls: []RPCCall{call("1", "block", `{"height":"5"}`)}},
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error"}}`, false},
+		{"call that needs no verification", &RPCRequest{Batch: true, Calls: []RPCCall{call("1", "health", `{}`)}},
+			`{"jsonrpc":"2.0","id":1,"result":{}}`, false},
+		{"abci_query without proof", &RPCRequest{Calls: []RPCCall{call("1", "abci_query", `{"path":"/store/bank/key","data":"61"}`)}},
+			`{"jsonrpc":"2.0","id":1,"result":{}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.verifyResponse(context.Background(), "http://upstream", tt.rpc, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsRequestsWithoutCalls(t *testing.T) {
+	upstream := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{upstream}, nil)
+	h.verifier = &lightclient.Verifier{}
+	w := serve(h, http.MethodPost, "/", "")
+	if w.Code != http.StatusBadRequest || len(upstream.received()) > 0 {
+		t.Fatalf("status = %d, upstream received %q; want 400 without forwarding", w.Code, upstream.received())
+	}
+}
+
+// TestVerifyDiskCacheHits 检查磁盘缓存中未经验证的结果不会直接返回，验证失败的条目被删除
+func TestVerifyDiskCacheHits(t *testing.T) {
+	disk, err := cache.OpenDiskStore(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := RPCCall{Method: "block", Params: json.RawMessage(`{"height":"5"}`)}
+	key := normalizedKey(call)
+	if err := disk.Put(key, "block", 5, json.RawMessage(`{"block":"forged"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := newTestUpstream(t, nil)
+	h := newTestHandler(t, []*testUpstream{upstream}, func(opts *proxyOptions) {
+		opts.CacheSize = 1 << 20
+		opts.DiskCache = disk
+	})
+	h.verifier = &lightclient.Verifier{}
+	w := serve(h, http.MethodGet, "/block?height=5", "")
+	if w.Header().Get("X-Cache") == "HIT" || w.Code == http.StatusOK {
+		t.Fatalf("unverified disk entry served: %d %s", w.Code, w.Body)
+	}
+	if _, ok := disk.Get(key); ok {
+		t.Error("disk entry that failed verification was kept")
+	}
+}
@@ -0,0 +1,744 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsReconnectAttempts 是上游断开后重连的最大次数
+	wsReconnectAttempts = 10
+	// wsReconnectBackoff 是每轮尝试完所有上游后的等待时间
+	wsReconnectBackoff = time.Second
+	// wsWriteTimeout 是写入单条消息的超时时间
+	wsWriteTimeout = 10 * time.Second
+	// wsDialTimeout 是连接上游 WebSocket 的握手超时时间
+	wsDialTimeout = 10 * time.Second
+	// wsClientBuffer 是每个客户端的发送缓冲，写满说明客户端太慢，直接断开
+	wsClientBuffer = 256
+	// wsUpstreamBuffer 是上游连接的发送缓冲，也是连接建立前最多排队的调用数
+	wsUpstreamBuffer = 1024
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// 代理接受任意来源的连接。上游连接由代理自己建立，上游看不到客户端的 Origin，
+	// 需要限制来源时应在代理前面配置
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// wsMessage 是 WebSocket 上 JSON-RPC 消息中需要关心的字段
+type wsMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// subscriptionQuery 取出 subscribe/unsubscribe 调用中的 query 参数，兼容对象和数组两种写法
+func subscriptionQuery(params json.RawMessage) string {
+	var named struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(params, &named); err == nil && named.Query != "" {
+		return named.Query
+	}
+	var positional []string
+	if err := json.Unmarshal(params, &positional); err == nil && len(positional) > 0 {
+		return positional[0]
+	}
+	return ""
+}
+
+// wsUpstreamURL 将上游的 http(s) 地址转换为 /websocket 的 ws(s) 地址
+func wsUpstreamURL(targetURL string) string {
+	u := strings.TrimSuffix(targetURL, "/") + "/websocket"
+	switch {
+	case strings.HasPrefix(u, "https://"):
+		return "wss://" + strings.TrimPrefix(u, "https://")
+	case strings.HasPrefix(u, "http://"):
+		return "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	return u
+}
+
+// encodeRPC 使用给定 id 重新编码一条响应或事件
+func encodeRPC(id json.RawMessage, m wsMessage) []byte {
+	msg, _ := json.Marshal(RPCResponse{Jsonrpc: "2.0", ID: id, Result: m.Result, Error: m.Error})
+	return msg
+}
+
+// wsClient 是一个下游 WebSocket 连接
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// subs 记录客户端订阅的 query 及其订阅 id，由 wsHub.mu 保护
+	subs map[string]json.RawMessage
+}
+
+// writePump 将发送缓冲中的消息依次写给客户端
+func (c *wsClient) writePump() {
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.close(0, "")
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// deliver 非阻塞地投递一条消息，缓冲已满时断开客户端
+func (c *wsClient) deliver(msg []byte) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		// deliver 通常在持有 wsHub.mu 时调用，发送关闭帧可能阻塞，只标记断开，在后台关闭连接
+		if c.markClosed() {
+			log.Printf("WebSocket client %s too slow, disconnecting", c.conn.RemoteAddr())
+			go c.closeConn(websocket.ClosePolicyViolation, "client too slow", time.Now().Add(wsWriteTimeout))
+		}
+	}
+}
+
+// close 关闭客户端连接，code 非 0 时先发送关闭帧
+func (c *wsClient) close(code int, reason string) {
+	if c.markClosed() {
+		c.closeConn(code, reason, time.Now().Add(wsWriteTimeout))
+	}
+}
+
+// markClosed 标记客户端已断开，之后的消息不再投递。只有第一次调用返回 true，由调用方关闭连接
+func (c *wsClient) markClosed() bool {
+	marked := false
+	c.closeOnce.Do(func() {
+		close(c.done)
+		marked = true
+	})
+	return marked
+}
+
+// closeConn 关闭底层连接，code 非 0 时先发送关闭帧，最多等到 deadline
+func (c *wsClient) closeConn(code int, reason string, deadline time.Time) {
+	if code != 0 {
+		msg := websocket.FormatCloseMessage(code, reason)
+		c.conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+	c.conn.Close()
+}
+
+// wsUpstreamConn 是一条上游 WebSocket 连接，写入由 writePump 完成，不占用 wsHub.mu
+type wsUpstreamConn struct {
+	conn      *websocket.Conn
+	upstream  *Upstream
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSUpstreamConn(conn *websocket.Conn, upstream *Upstream) *wsUpstreamConn {
+	return &wsUpstreamConn{
+		conn:     conn,
+		upstream: upstream,
+		send:     make(chan []byte, wsUpstreamBuffer),
+		done:     make(chan struct{}),
+	}
+}
+
+// writePump 将发送缓冲中的消息依次写给上游，写入失败时关闭连接，由 read 负责重连
+func (u *wsUpstreamConn) writePump() {
+	for {
+		select {
+		case msg := <-u.send:
+			u.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := u.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				u.close()
+				return
+			}
+		case <-u.done:
+			return
+		}
+	}
+}
+
+// trySend 非阻塞地投递一条消息，缓冲已满时关闭连接
+func (u *wsUpstreamConn) trySend(msg []byte) error {
+	select {
+	case u.send <- msg:
+		return nil
+	case <-u.done:
+		return fmt.Errorf("upstream %s disconnected", u.upstream.URL)
+	default:
+		u.close()
+		return fmt.Errorf("upstream %s too slow", u.upstream.URL)
+	}
+}
+
+func (u *wsUpstreamConn) close() {
+	u.closeOnce.Do(func() {
+		close(u.done)
+		u.conn.Close()
+	})
+}
+
+// wsSubscription 是一个上游订阅，被所有订阅相同 query 的客户端共享
+type wsSubscription struct {
+	id      string // 发往上游的订阅 id
+	query   string
+	slot    *wsSlot
+	clients map[*wsClient]json.RawMessage
+	// confirmed 表示上游已确认订阅；确认前加入的客户端记录在 acks 中，确认后才回复
+	confirmed bool
+	acks      map[*wsClient]json.RawMessage
+}
+
+// wsPendingCall 是一个经由共享连接转发、等待上游响应的普通调用
+type wsPendingCall struct {
+	client *wsClient
+	id     json.RawMessage
+	slot   *wsSlot
+}
+
+// wsSlot 是一条共享的上游连接及其承载的订阅。CometBFT 默认每个连接最多 5 个订阅
+// （max_subscriptions_per_client），订阅因此分散在多条连接上
+type wsSlot struct {
+	conn       *wsUpstreamConn
+	connecting bool
+	removed    bool
+	queue      [][]byte // 连接建立前排队的调用
+	subs       map[string]*wsSubscription
+}
+
+// wsHub 为所有下游客户端维护共享的上游 WebSocket 连接，
+// 每个不同的 query 只在上游订阅一次，事件再按引用分发给各个客户端。
+// 普通调用都经由第一条连接转发。拨号和重连在单独的 goroutine 中进行，不持有 mu，
+// 期间的调用排队等待连接建立
+type wsHub struct {
+	pool *UpstreamPool
+	// maxSubs 是每条上游连接最多承载的订阅数，0 表示不限制
+	maxSubs int
+	// ctx 在 shutdown 时取消，中止正在进行的拨号和重连等待
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	slots   []*wsSlot
+	nextID  uint64
+	clients map[*wsClient]bool
+	subs    map[string]*wsSubscription // query -> 订阅
+	byID    map[string]*wsSubscription // 上游订阅 id -> 订阅
+	pending map[string]wsPendingCall   // 上游请求 id -> 普通调用
+	closed  bool                       // 已关闭，不再连接上游
+}
+
+func newWSHub(pool *UpstreamPool, maxSubs int) *wsHub {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &wsHub{
+		pool:    pool,
+		maxSubs: maxSubs,
+		ctx:     ctx,
+		cancel:  cancel,
+		clients: make(map[*wsClient]bool),
+		subs:    make(map[string]*wsSubscription),
+		byID:    make(map[string]*wsSubscription),
+		pending: make(map[string]wsPendingCall),
+	}
+}
+
+// serveWebSocket 升级客户端连接并接入共享的上游连接
+func (h *proxyHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, client clientInfo) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading WebSocket connection: %v", err)
+		return
+	}
+
+	c := &wsClient{
+		conn: conn,
+		send: make(chan []byte, wsClientBuffer),
+		done: make(chan struct{}),
+		subs: make(map[string]json.RawMessage),
+	}
+	h.ws.add(c)
+	defer h.ws.remove(c)
+	go c.writePump()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			c.close(0, "")
+			return
+		}
+		// WebSocket 上的调用同样需要通过准入检查
+		var call RPCCall
+		json.Unmarshal(msg, &call)
+		cost, err := h.opts.Costs.Cost(call)
+		if err != nil {
+			c.deliver(encodeRPC(call.ID, wsMessage{Error: &RPCError{Code: codeInvalidParams, Message: "Invalid params", Data: err.Error()}}))
+			continue
+		}
+		if err := h.admit(r.Context(), client, []RPCCall{call}, cost); err != nil {
+			c.deliver(encodeRPC(call.ID, wsMessage{Error: &RPCError{Code: err.code, Message: err.message, Data: err.err.Error()}}))
+			continue
+		}
+		h.ws.handle(c, msg)
+	}
+}
+
+func (hub *wsHub) add(c *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.clients[c] = true
+}
+
+// remove 移除客户端及其所有订阅，没有客户端引用的上游订阅会被取消
+func (hub *wsHub) remove(c *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.clients, c)
+	for query := range c.subs {
+		hub.unsubscribeLocked(c, query)
+	}
+	for id, call := range hub.pending {
+		if call.client == c {
+			delete(hub.pending, id)
+		}
+	}
+}
+
+// shutdown 向所有客户端并行发送关闭帧并断开上游连接，之后不再重连。
+// 关闭帧最多等到 ctx 结束，慢客户端不会拖住其他客户端
+func (hub *wsHub) shutdown(ctx context.Context) {
+	hub.mu.Lock()
+	hub.closed = true
+	hub.cancel()
+	clients := make([]*wsClient, 0, len(hub.clients))
+	for c := range hub.clients {
+		clients = append(clients, c)
+	}
+	var conns []*wsUpstreamConn
+	for _, slot := range hub.slots {
+		if slot.conn != nil {
+			conns = append(conns, slot.conn)
+		}
+		slot.conn, slot.queue = nil, nil
+	}
+	hub.mu.Unlock()
+
+	deadline := time.Now().Add(wsWriteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	// 客户端断开后会调用 remove，需要在锁外关闭
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		if !c.markClosed() {
+			continue
+		}
+		wg.Add(1)
+		go func(c *wsClient) {
+			defer wg.Done()
+			c.closeConn(websocket.CloseGoingAway, "server shutting down", deadline)
+		}(c)
+	}
+	wg.Wait()
+	for _, conn := range conns {
+		conn.close()
+	}
+	log.Printf("Closed %d WebSocket clients", len(clients))
+}
+
+// handle 处理一条客户端消息
+func (hub *wsHub) handle(c *wsClient, raw []byte) {
+	var m wsMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		c.deliver(encodeRPC(json.RawMessage("null"), wsMessage{Error: &RPCError{Code: codeParseError, Message: "Parse error", Data: err.Error()}}))
+		return
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	ack := wsMessage{Result: json.RawMessage("{}")}
+	switch m.Method {
+	case "subscribe":
+		query := subscriptionQuery(m.Params)
+		if query == "" {
+			break
+		}
+		// 确认由 subscribeLocked 在上游确认后发出
+		if err := hub.subscribeLocked(c, query, m.ID); err != nil {
+			c.deliver(encodeRPC(m.ID, wsMessage{Error: &RPCError{Code: codeUpstreamsFailed, Message: "Failed to subscribe", Data: err.Error()}}))
+		}
+		return
+	case "unsubscribe":
+		query := subscriptionQuery(m.Params)
+		if _, ok := c.subs[query]; !ok {
+			c.deliver(encodeRPC(m.ID, wsMessage{Error: &RPCError{Code: codeInternalError, Message: "Internal error", Data: "subscription not found"}}))
+			return
+		}
+		hub.unsubscribeLocked(c, query)
+		c.deliver(encodeRPC(m.ID, ack))
+		return
+	case "unsubscribe_all":
+		for query := range c.subs {
+			hub.unsubscribeLocked(c, query)
+		}
+		c.deliver(encodeRPC(m.ID, ack))
+		return
+	}
+
+	// 其他调用换成共享连接上的 id 转发，响应再换回客户端的 id
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		c.deliver(encodeRPC(json.RawMessage("null"), wsMessage{Error: &RPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: err.Error()}}))
+		return
+	}
+	id := hub.newIDLocked()
+	fields["id"] = json.RawMessage(id)
+	msg, _ := json.Marshal(fields)
+	slot := hub.callSlotLocked()
+	hub.pending[id] = wsPendingCall{client: c, id: m.ID, slot: slot}
+	if err := hub.sendLocked(slot, msg, true); err != nil {
+		delete(hub.pending, id)
+		c.deliver(encodeRPC(m.ID, wsMessage{Error: &RPCError{Code: codeUpstreamsFailed, Message: "Upstream unavailable", Data: err.Error()}}))
+	}
+}
+
+// callSlotLocked 返回转发普通调用的连接，即第一条连接
+func (hub *wsHub) callSlotLocked() *wsSlot {
+	if len(hub.slots) == 0 {
+		hub.slots = append(hub.slots, &wsSlot{subs: make(map[string]*wsSubscription)})
+	}
+	return hub.slots[0]
+}
+
+// subSlotLocked 返回还能承载订阅的连接，都满了时新建一条
+func (hub *wsHub) subSlotLocked() *wsSlot {
+	for _, slot := range hub.slots {
+		if hub.maxSubs <= 0 || len(slot.subs) < hub.maxSubs {
+			return slot
+		}
+	}
+	slot := &wsSlot{subs: make(map[string]*wsSubscription)}
+	hub.slots = append(hub.slots, slot)
+	return slot
+}
+
+// subscribeLocked 将客户端加入 query 对应的订阅，必要时在上游新建订阅。
+// 上游已确认的订阅立即回复客户端，否则等上游确认后在 dispatch 中回复
+func (hub *wsHub) subscribeLocked(c *wsClient, query string, clientID json.RawMessage) error {
+	sub := hub.subs[query]
+	if sub == nil {
+		slot := hub.subSlotLocked()
+		sub = &wsSubscription{
+			id:      hub.newIDLocked(),
+			query:   query,
+			slot:    slot,
+			clients: make(map[*wsClient]json.RawMessage),
+			acks:    make(map[*wsClient]json.RawMessage),
+		}
+		if err := hub.sendLocked(slot, subscribeMessage(sub.id, "subscribe", query), false); err != nil {
+			hub.releaseSlotLocked(slot)
+			return err
+		}
+		hub.subs[query] = sub
+		hub.byID[sub.id] = sub
+		slot.subs[query] = sub
+		log.Printf("WebSocket subscribed upstream to %q", query)
+	}
+	sub.clients[c] = clientID
+	c.subs[query] = clientID
+	if sub.confirmed {
+		c.deliver(encodeRPC(clientID, wsMessage{Result: json.RawMessage("{}")}))
+	} else {
+		sub.acks[c] = clientID
+	}
+	return nil
+}
+
+// unsubscribeLocked 将客户端移出订阅，最后一个客户端离开时取消上游订阅
+func (hub *wsHub) unsubscribeLocked(c *wsClient, query string) {
+	delete(c.subs, query)
+	sub := hub.subs[query]
+	if sub == nil {
+		return
+	}
+	delete(sub.clients, c)
+	delete(sub.acks, c)
+	if len(sub.clients) > 0 {
+		return
+	}
+
+	hub.removeSubLocked(sub)
+	if sub.slot.conn != nil {
+		if err := hub.sendLocked(sub.slot, subscribeMessage(hub.newIDLocked(), "unsubscribe", query), false); err != nil {
+			log.Printf("Error unsubscribing %q upstream: %v", query, err)
+		}
+	}
+	hub.releaseSlotLocked(sub.slot)
+	log.Printf("WebSocket unsubscribed upstream from %q", query)
+}
+
+// removeSubLocked 从 hub 和所在的连接中删除订阅
+func (hub *wsHub) removeSubLocked(sub *wsSubscription) {
+	delete(hub.subs, sub.query)
+	delete(hub.byID, sub.id)
+	delete(sub.slot.subs, sub.query)
+}
+
+// releaseSlotLocked 关闭不再承载订阅的连接，第一条连接用于普通调用，始终保留
+func (hub *wsHub) releaseSlotLocked(slot *wsSlot) {
+	if len(slot.subs) > 0 || len(hub.slots) == 0 || hub.slots[0] == slot {
+		return
+	}
+	for i, s := range hub.slots {
+		if s == slot {
+			hub.slots = append(hub.slots[:i], hub.slots[i+1:]...)
+			break
+		}
+	}
+	slot.removed = true
+	if slot.conn != nil {
+		slot.conn.close()
+		slot.conn = nil
+	}
+}
+
+func (hub *wsHub) newIDLocked() string {
+	hub.nextID++
+	return fmt.Sprintf(`"tedtool-%d"`, hub.nextID)
+}
+
+func subscribeMessage(id, method, query string) []byte {
+	msg, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      json.RawMessage(id),
+		"method":  method,
+		"params":  map[string]string{"query": query},
+	})
+	return msg
+}
+
+// sendLocked 经由 slot 向上游发送一条消息，尚未连接时在后台建立连接。
+// queue 为 true 的调用排队到连接建立后发送；订阅不需要排队，连接建立后会统一重新订阅
+func (hub *wsHub) sendLocked(slot *wsSlot, msg []byte, queue bool) error {
+	if hub.closed {
+		return fmt.Errorf("server shutting down")
+	}
+	if slot.conn != nil {
+		return slot.conn.trySend(msg)
+	}
+	hub.connectLocked(slot, nil)
+	if !queue {
+		return nil
+	}
+	if len(slot.queue) >= wsUpstreamBuffer {
+		return fmt.Errorf("too many calls waiting for the upstream connection")
+	}
+	slot.queue = append(slot.queue, msg)
+	return nil
+}
+
+// connectLocked 在后台为 slot 连接上游，已经在连接时什么都不做
+func (hub *wsHub) connectLocked(slot *wsSlot, failed *Upstream) {
+	if slot.connecting || hub.closed {
+		return
+	}
+	slot.connecting = true
+	go hub.reconnect(slot, failed)
+}
+
+// reconnect 为 slot 连接上游，成功后重新发送它的订阅和排队的调用；
+// 放弃时通知等待中的调用并断开订阅了这条连接的客户端
+func (hub *wsHub) reconnect(slot *wsSlot, failed *Upstream) {
+	conn, upstream, err := hub.dial(failed)
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	slot.connecting = false
+	if hub.closed || slot.removed {
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error connecting WebSocket upstream: %v", err)
+		hub.failPendingLocked(slot, "Upstream unavailable", err)
+		slot.queue = nil
+		// 发送关闭帧可能阻塞，不能持有锁；客户端断开后会调用 remove
+		var clients []*wsClient
+		for _, sub := range slot.subs {
+			for c := range sub.clients {
+				clients = append(clients, c)
+			}
+		}
+		go func() {
+			for _, c := range clients {
+				c.close(websocket.CloseTryAgainLater, "upstream unavailable")
+			}
+		}()
+		return
+	}
+
+	uc := newWSUpstreamConn(conn, upstream)
+	slot.conn = uc
+	go uc.writePump()
+	go hub.read(slot, uc)
+	// 重新发送订阅请求，已确认订阅的确认消息会在 dispatch 中被忽略
+	for _, sub := range slot.subs {
+		uc.trySend(subscribeMessage(sub.id, "subscribe", sub.query))
+	}
+	for _, msg := range slot.queue {
+		uc.trySend(msg)
+	}
+	slot.queue = nil
+	log.Printf("WebSocket connected to %s, resubscribed %d queries", upstream.URL, len(slot.subs))
+}
+
+// dial 依次尝试健康的上游，直到连接成功、次数用尽或 hub 关闭
+func (hub *wsHub) dial(failed *Upstream) (*websocket.Conn, *Upstream, error) {
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: wsDialTimeout}
+	var tried []*Upstream
+	if failed != nil {
+		tried = append(tried, failed)
+	}
+
+	for attempt := 0; attempt < wsReconnectAttempts; attempt++ {
+		upstream := hub.pool.Pick(tried, route{})
+		if upstream == nil {
+			// 所有上游都试过了，等待一段时间后重新开始
+			tried = nil
+			select {
+			case <-time.After(wsReconnectBackoff):
+			case <-hub.ctx.Done():
+				return nil, nil, hub.ctx.Err()
+			}
+			continue
+		}
+		tried = append(tried, upstream)
+
+		conn, _, err := dialer.DialContext(hub.ctx, wsUpstreamURL(upstream.URL), nil)
+		if err != nil {
+			if hub.ctx.Err() != nil {
+				return nil, nil, hub.ctx.Err()
+			}
+			log.Printf("Error dialing WebSocket %s: %v", upstream.URL, err)
+			hub.pool.ReportFailure(upstream)
+			continue
+		}
+		hub.pool.ReportSuccess(upstream, 0)
+		return conn, upstream, nil
+	}
+	return nil, nil, fmt.Errorf("gave up after %d attempts", wsReconnectAttempts)
+}
+
+// read 读取上游消息并分发，连接断开时重连
+func (hub *wsHub) read(slot *wsSlot, uc *wsUpstreamConn) {
+	for {
+		_, msg, err := uc.conn.ReadMessage()
+		if err != nil {
+			hub.disconnected(slot, uc, err)
+			return
+		}
+		hub.dispatch(msg)
+	}
+}
+
+// disconnected 处理上游断开：经由这条连接的调用返回错误，仍有订阅时在后台切换到下一个上游
+func (hub *wsHub) disconnected(slot *wsSlot, uc *wsUpstreamConn, err error) {
+	uc.close()
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if slot.conn != uc {
+		return
+	}
+
+	log.Printf("Upstream WebSocket %s disconnected: %v", uc.upstream.URL, err)
+	hub.pool.ReportFailure(uc.upstream)
+	slot.conn = nil
+	hub.failPendingLocked(slot, "Upstream disconnected", err)
+	if len(slot.subs) > 0 {
+		hub.connectLocked(slot, uc.upstream)
+	}
+}
+
+// failPendingLocked 向经由 slot 等待上游响应的调用返回错误
+func (hub *wsHub) failPendingLocked(slot *wsSlot, message string, err error) {
+	for id, call := range hub.pending {
+		if call.slot != slot {
+			continue
+		}
+		call.client.deliver(encodeRPC(call.id, wsMessage{Error: &RPCError{Code: codeUpstreamsFailed, Message: message, Data: err.Error()}}))
+		delete(hub.pending, id)
+	}
+}
+
+// dispatch 将上游消息转发给对应的客户端
+func (hub *wsHub) dispatch(raw []byte) {
+	var m wsMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	id := string(m.ID)
+
+	if call, ok := hub.pending[id]; ok {
+		delete(hub.pending, id)
+		call.client.deliver(encodeRPC(call.id, m))
+		return
+	}
+
+	sub := hub.byID[id]
+	if sub == nil {
+		return
+	}
+	if m.Error != nil {
+		// 上游拒绝了订阅，通知所有订阅者并移除
+		log.Printf("Upstream rejected subscription %q: %s", sub.query, m.Error.Message)
+		for c, clientID := range sub.clients {
+			c.deliver(encodeRPC(clientID, m))
+			delete(c.subs, sub.query)
+		}
+		hub.removeSubLocked(sub)
+		hub.releaseSlotLocked(sub.slot)
+		return
+	}
+
+	// 订阅确认的 result 为空对象，事件消息则带有 query 字段
+	var result struct {
+		Query string `json:"query"`
+	}
+	if json.Unmarshal(m.Result, &result) != nil || result.Query == "" {
+		if !sub.confirmed {
+			sub.confirmed = true
+			for c, clientID := range sub.acks {
+				c.deliver(encodeRPC(clientID, m))
+			}
+			sub.acks = nil
+		}
+		return
+	}
+	for c, clientID := range sub.clients {
+		c.deliver(encodeRPC(clientID, m))
+	}
+}
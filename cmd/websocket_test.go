@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestWSClient 返回一对相连的 WebSocket 连接：代理一侧的 wsClient 和客户端一侧的连接
+func newTestWSClient(t *testing.T) (*wsClient, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	c := &wsClient{
+		conn: <-conns,
+		send: make(chan []byte, wsClientBuffer),
+		done: make(chan struct{}),
+		subs: make(map[string]json.RawMessage),
+	}
+	return c, peer
+}
+
+// TestDeliverDisconnectsSlowClient 检查缓冲写满的客户端被立即标记为断开，
+// 关闭帧在后台发送，deliver 在持有 wsHub.mu 时不会阻塞
+func TestDeliverDisconnectsSlowClient(t *testing.T) {
+	c, peer := newTestWSClient(t)
+	for i := 0; i < wsClientBuffer; i++ {
+		c.deliver([]byte(`{}`))
+	}
+
+	hub := newWSHub(nil, 0)
+	hub.mu.Lock()
+	start := time.Now()
+	c.deliver([]byte(`{}`))
+	c.deliver([]byte(`{}`))
+	hub.mu.Unlock()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("deliver blocked for %v", elapsed)
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("slow client not marked as closed")
+	}
+
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := peer.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("ReadMessage() error = %v, want close %d", err, websocket.ClosePolicyViolation)
+	}
+}
+
+// testWSUpstream 是假的上游 WebSocket 端点，确认所有订阅，普通调用返回带方法名的结果
+type testWSUpstream struct {
+	*testUpstream
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	messages []wsMessage
+}
+
+func newTestWSUpstream(t *testing.T) *testWSUpstream {
+	t.Helper()
+	u := &testWSUpstream{}
+	u.testUpstream = newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		u.mu.Lock()
+		u.conn = conn
+		u.mu.Unlock()
+		for {
+			var m wsMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			u.mu.Lock()
+			u.messages = append(u.messages, m)
+			result := json.RawMessage(`{}`)
+			if m.Method != "subscribe" && m.Method != "unsubscribe" {
+				result = json.RawMessage(fmt.Sprintf(`{"method":%q}`, m.Method))
+			}
+			conn.WriteMessage(websocket.TextMessage, encodeRPC(m.ID, wsMessage{Result: result}))
+			u.mu.Unlock()
+		}
+	})
+	return u
+}
+
+// methods 返回上游收到的调用的方法名
+func (u *testWSUpstream) methods() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var out []string
+	for _, m := range u.messages {
+		out = append(out, m.Method)
+	}
+	return out
+}
+
+// publish 向上游收到的第一个订阅推送一个事件
+func (u *testWSUpstream) publish(query string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	subID := u.messages[0].ID
+	u.conn.WriteMessage(websocket.TextMessage, encodeRPC(subID, wsMessage{Result: json.RawMessage(fmt.Sprintf(`{"query":%q,"data":{}}`, query))}))
+}
+
+// TestWebSocketSharedSubscription 检查相同 query 的订阅在上游只订阅一次，事件分发给每个客户端，
+// 普通调用的 id 被换回客户端的 id，最后一个客户端离开时取消上游订阅
+func TestWebSocketSharedSubscription(t *testing.T) {
+	upstream := newTestWSUpstream(t)
+	h := newTestHandler(t, []*testUpstream{upstream.testUpstream}, func(opts *proxyOptions) { opts.WSMaxSubs = 5 })
+	t.Cleanup(func() { h.ws.shutdown(context.Background()) })
+	proxy := httptest.NewServer(h)
+	t.Cleanup(proxy.Close)
+
+	dial := func() *websocket.Conn {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return c
+	}
+	read := func(c *websocket.Conn) RPCResponse {
+		t.Helper()
+		var resp RPCResponse
+		if err := c.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	const query = "tm.event='NewBlock'"
+	a, b := dial(), dial()
+	for i, c := range []*websocket.Conn{a, b} {
+		c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": i + 1, "method": "subscribe", "params": map[string]string{"query": query}})
+		if resp := read(c); string(resp.ID) != fmt.Sprint(i+1) || resp.Error != nil {
+			t.Fatalf("subscribe ack = %+v", resp)
+		}
+	}
+	if got := upstream.methods(); len(got) != 1 || got[0] != "subscribe" {
+		t.Fatalf("upstream received %v, want a single subscribe", got)
+	}
+
+	upstream.publish(query)
+	for i, c := range []*websocket.Conn{a, b} {
+		if resp := read(c); string(resp.ID) != fmt.Sprint(i+1) || !strings.Contains(string(resp.Result), query) {
+			t.Errorf("client %d event = %s %s", i, resp.ID, resp.Result)
+		}
+	}
+
+	a.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": "call", "method": "status"})
+	if resp := read(a); string(resp.ID) != `"call"` || !strings.Contains(string(resp.Result), "status") {
+		t.Errorf("call response = %s %s", resp.ID, resp.Result)
+	}
+
+	for _, c := range []*websocket.Conn{a, b} {
+		c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 9, "method": "unsubscribe", "params": map[string]string{"query": query}})
+		read(c)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := upstream.methods()
+		if got[len(got)-1] == "unsubscribe" {
+			if n := strings.Count(strings.Join(got, " "), "unsubscribe"); n != 1 {
+				t.Errorf("upstream received %v, want one unsubscribe", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upstream received %v, want an unsubscribe after the last client left", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWSHelpers(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"http://node:26657", "ws://node:26657/websocket"},
+		{"https://rpc.example.com/cosmos/", "wss://rpc.example.com/cosmos/websocket"},
+	} {
+		if got := wsUpstreamURL(tt.in); got != tt.want {
+			t.Errorf("wsUpstreamURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, params := range []string{`{"query":"tm.event='Tx'"}`, `["tm.event='Tx'"]`} {
+		if got := subscriptionQuery(json.RawMessage(params)); got != "tm.event='Tx'" {
+			t.Errorf("subscriptionQuery(%s) = %q", params, got)
+		}
+	}
+}